@@ -5,7 +5,10 @@ import (
 	"os"
 
 	"github.com/praveensastry/cm/internal/config"
+	"github.com/praveensastry/cm/internal/content"
+	"github.com/praveensastry/cm/internal/engine"
 	"github.com/praveensastry/cm/internal/parser"
+	"github.com/praveensastry/cm/internal/servers"
 	"github.com/praveensastry/cm/terminal"
 	"github.com/urfave/cli"
 )
@@ -29,14 +32,46 @@ func main() {
 				cfg := getConfig()
 				terminal.Information(fmt.Sprintf("There are [%d] remote servers configured currently", len(cfg.Servers)))
 				cfg.Servers.PrintAllServerInfo()
+				if len(cfg.Groups) > 0 {
+					terminal.Information(fmt.Sprintf("There are [%d] groups configured currently", len(cfg.Groups)))
+					cfg.Groups.PrintGroupInfo()
+				}
 				return nil
 			},
 		},
 		{
 			Name:        "configure",
 			ShortName:   "c",
-			Usage:       "cm configure <spec>",
-			Description: "Configure one or many remote servers with a given spec",
+			Usage:       "cm configure <spec|server|group>",
+			Description: "Configure one or many remote servers with a given spec or name, or in a given group",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "strict-host-keys",
+					Usage: "fail on unknown host keys instead of asking to trust them, for non-interactive runs",
+				},
+				cli.BoolFlag{
+					Name:  "plan",
+					Usage: "show what would change on each server without changing anything",
+				},
+				cli.BoolFlag{
+					Name:  "confirm-each",
+					Usage: "ask before overwriting each existing file that would change",
+				},
+				cli.BoolFlag{
+					Name:  "flat",
+					Usage: "run all pre-configure commands, then all packages, files and post-configure commands across the REQUIRES tree, instead of one spec at a time",
+				},
+				cli.BoolFlag{
+					Name:  "ignore-lock",
+					Usage: "only warn when the specs don't match cm.lock, instead of refusing to configure",
+				},
+				cli.BoolFlag{
+					Name:  "offline",
+					Usage: "configure with the cached clones of git specs and content that can't be fetched, instead of refusing",
+				},
+				varFileFlag,
+				varFlag,
+			},
 			Action: func(c *cli.Context) error {
 				specList, err := parser.GetSpecs()
 				if err != nil {
@@ -44,8 +79,97 @@ func main() {
 					return err
 				}
 
+				vars, err := readVarFlags(c)
+				if err != nil {
+					terminal.ShowErrorMessage("Error Reading Variables!", err.Error())
+					return err
+				}
+
+				lock, err := parser.ReadLock(lockFile)
+				if err != nil {
+					terminal.ShowErrorMessage("Error Reading "+lockFile+"!", err.Error())
+					return err
+				}
+
 				cfg := getConfig()
-				cfg.Servers.RemoteConfigure(c.Args().Get(0), specList)
+				cfg.Servers.RemoteConfigure(c.Args().Get(0), specList, servers.ConfigureOptions{
+					StrictHostKeys: c.Bool("strict-host-keys"),
+					Plan:           c.Bool("plan"),
+					ConfirmEach:    c.Bool("confirm-each"),
+					Flat:           c.Bool("flat"),
+					Lock:           lock,
+					IgnoreLock:     c.Bool("ignore-lock"),
+					Offline:        c.Bool("offline"),
+					Vars:           vars,
+				})
+				return nil
+			},
+		},
+		{
+			Name:        "lock",
+			ShortName:   "lk",
+			Usage:       "cm lock [spec|server]",
+			Description: "Pin the specs of a spec, of the servers with a given name or spec, or of every server in cm.lock",
+			Action: func(c *cli.Context) error {
+				specList, err := parser.GetSpecs()
+				if err != nil {
+					terminal.ShowErrorMessage("Error Reading Spec Files!", err.Error())
+					return err
+				}
+
+				// Lock the named spec, or the specs of the matching servers
+				search := c.Args().Get(0)
+				var specNames []string
+				if specList.SpecExists(search) {
+					specNames = []string{search}
+				} else {
+					targets := getConfig().Servers
+					if search != "" {
+						targets = targets.Matching(search)
+					}
+					for _, server := range targets {
+						if server.Spec == "" {
+							terminal.Notice(fmt.Sprintf("Skipping server [%s], it has no spec to lock", server.Name))
+							continue
+						}
+						specNames = append(specNames, server.Spec)
+					}
+				}
+				if len(specNames) == 0 {
+					return cli.NewExitError(fmt.Sprintf("I couldn't find any spec or servers named [%s]", search), 1)
+				}
+
+				// The commit of git content is locked too
+				for _, specName := range specNames {
+					if err := specList.PrepareContent(specName, content.CacheDir()); err != nil {
+						terminal.ShowErrorMessage("Unable to fetch content!", err.Error())
+						return err
+					}
+				}
+
+				lock, err := specList.Lock(specNames...)
+				if err != nil {
+					terminal.ShowErrorMessage("Unable to lock the specs!", err.Error())
+					return err
+				}
+
+				// Keep the specs other servers were locked to
+				existing, err := parser.ReadLock(lockFile)
+				if err != nil {
+					terminal.ShowErrorMessage("Error Reading "+lockFile+"!", err.Error())
+					return err
+				}
+				if existing != nil {
+					existing.Merge(lock)
+					lock = existing
+				}
+
+				if err := lock.Save(lockFile); err != nil {
+					terminal.ShowErrorMessage("Unable to write "+lockFile+"!", err.Error())
+					return err
+				}
+
+				terminal.Information(fmt.Sprintf("Locked [%d] specs in %s", len(lock.Specs), lockFile))
 				return nil
 			},
 		},
@@ -90,12 +214,25 @@ func main() {
 		{
 			Name:        "describe-spec",
 			ShortName:   "ds",
-			Usage:       "cm describe-spec",
+			Usage:       "cm describe-spec <spec>",
 			Description: "Show what a given spec will build",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "vars",
+					Usage: "show the value of each variable and where it came from, instead of the build",
+				},
+				cli.StringFlag{
+					Name:  "host",
+					Usage: "with --vars, include the vars of this host",
+				},
+				varFileFlag,
+				varFlag,
+			},
 			Action: func(c *cli.Context) error {
 				specList, err := parser.GetSpecs()
 				if err != nil {
 					terminal.ShowErrorMessage("Error Reading Spec Files!", err.Error())
+					return err
 				}
 
 				specName := c.Args().Get(0)
@@ -105,17 +242,129 @@ func main() {
 					return nil
 				}
 
+				if _, err := specList.Resolve(specName); err != nil {
+					terminal.ShowErrorMessage("Unable to resolve spec requirements!", err.Error())
+				}
+
+				if c.Bool("vars") {
+					return describeVars(c, specList, specName)
+				}
+
+				// Remote content has to be fetched to list its files
+				if err := specList.PrepareContent(specName, content.CacheDir()); err != nil {
+					terminal.ShowErrorMessage("Unable to fetch content!", err.Error())
+				}
+				for _, origin := range specList.ContentOrigins(specName) {
+					terminal.Information("Content from " + origin)
+				}
+
 				specList.ShowSpecBuild(specName)
 				return nil
 			},
 		},
+		{
+			Name:        "validate",
+			ShortName:   "v",
+			Usage:       "cm validate [spec...]",
+			Description: "Check spec files for mistakes, all of them or just the given specs",
+			Action: func(c *cli.Context) error {
+				specList, err := parser.GetSpecs()
+				if err != nil {
+					terminal.ErrorLine(err.Error())
+				}
+
+				problems := specList.Validate(c.Args()...)
+				for _, problem := range problems {
+					terminal.ErrorLine(problem.String())
+				}
+
+				if err != nil || len(problems) > 0 {
+					return cli.NewExitError(fmt.Sprintf("Found [%d] problems in the spec files", len(problems)), 1)
+				}
+
+				terminal.Information("No problems found in the spec files")
+				return nil
+			},
+		},
 	}
 	app.Run(os.Args)
 }
 
+// Where cm lock pins the specs, and cm configure checks them against
+const lockFile = "cm.lock"
+
+// Variables given on the command line, which override everything else
+var (
+	varFileFlag = cli.StringSliceFlag{
+		Name:  "var-file",
+		Usage: "read variables from a file of name = value lines, later files win",
+	}
+	varFlag = cli.StringSliceFlag{
+		Name:  "var",
+		Usage: "set a variable as name=value, overriding any --var-file",
+	}
+)
+
+// Reads the --var-file and --var flags, lowest precedence first
+func readVarFlags(c *cli.Context) ([]parser.VarLayer, error) {
+	var layers []parser.VarLayer
+	for _, file := range c.StringSlice("var-file") {
+		layer, err := parser.ReadVarFile(file)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer)
+	}
+
+	if flags := c.StringSlice("var"); len(flags) > 0 {
+		layer, err := parser.ParseVarFlags(flags)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer)
+	}
+
+	return layers, nil
+}
+
+// Shows the value each variable of a spec ends up with, and where it came from
+func describeVars(c *cli.Context, specList *parser.SpecList, specName string) error {
+	class, sequence, locale := "", "", ""
+	var hostLayers []parser.VarLayer
+	if hostName := c.String("host"); hostName != "" {
+		var host *servers.Server
+		for _, server := range getConfig().Servers {
+			if server.Name == hostName {
+				host = &server
+				break
+			}
+		}
+		if host == nil {
+			return cli.NewExitError(fmt.Sprintf("I couldn't find a host named [%s]", hostName), 1)
+		}
+		class, sequence, locale = host.Class, host.Sequence, host.Locale
+		hostLayers = host.VarLayers()
+	}
+
+	flagLayers, err := readVarFlags(c)
+	if err != nil {
+		terminal.ShowErrorMessage("Error Reading Variables!", err.Error())
+		return err
+	}
+
+	vars := engine.ResolveVars(specList, specName, engine.NewVars(specName, class, sequence, locale), append(hostLayers, flagLayers...)...)
+	parser.PrintVars(vars)
+	return nil
+}
+
 func getConfig() *config.CMConfig {
 	// Check Config
 	cfg, err := config.ReadConfig()
+	if err != nil && len(cfg.Servers) > 0 {
+		// The inventory is there, but its groups don't add up
+		terminal.ShowErrorMessage("Error Reading ~/.cminventory!", err.Error())
+		os.Exit(1)
+	}
 	if err != nil || len(cfg.Servers) == 0 {
 		// No Config Found, ask if we want to create one
 		create := terminal.BoxPromptBool("configuration file not found or empty!", "Do you want to add some servers now?")
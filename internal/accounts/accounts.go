@@ -0,0 +1,179 @@
+package accounts
+
+import (
+	"strings"
+
+	"github.com/praveensastry/cm/internal/transport"
+)
+
+// A group as a spec wants it, empty fields are left to the system
+type Group struct {
+	Name   string
+	GID    string
+	System bool
+}
+
+// A user as a spec wants it, empty fields are left to the system
+type User struct {
+	Name   string
+	UID    string
+	Group  string   // the primary group
+	Groups []string // supplementary groups, the user is added to them but never removed from others
+	Shell  string
+	Home   string
+	System bool
+}
+
+// A user as it is on the target, read from its passwd entry
+type Passwd struct {
+	UID   string
+	GID   string
+	Home  string
+	Shell string
+}
+
+// Reads the passwd entry of a user, nil when the user doesn't exist
+func LookupUser(t transport.Transport, name string) (*Passwd, error) {
+	fields, err := getent(t, "passwd", name)
+	if err != nil || len(fields) < 7 {
+		return nil, err
+	}
+	return &Passwd{UID: fields[2], GID: fields[3], Home: fields[5], Shell: fields[6]}, nil
+}
+
+// Returns the commands that make a group as wanted, none when it already is
+func GroupCommands(t transport.Transport, group Group) ([]string, error) {
+	fields, err := getent(t, "group", group.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(fields) < 3 {
+		cmd := "sudo groupadd"
+		if group.GID != "" {
+			cmd += " -g " + transport.Quote(group.GID)
+		}
+		if group.System {
+			cmd += " -r"
+		}
+		return []string{cmd + " " + transport.Quote(group.Name)}, nil
+	}
+
+	if group.GID != "" && fields[2] != group.GID {
+		return []string{"sudo groupmod -g " + transport.Quote(group.GID) + " " + transport.Quote(group.Name)}, nil
+	}
+
+	return nil, nil
+}
+
+// Returns the commands that make a user as wanted, none when it already is
+func UserCommands(t transport.Transport, user User) ([]string, error) {
+	current, err := LookupUser(t, user.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	if current == nil {
+		return addUser(t, user)
+	}
+
+	var cmds []string
+
+	var options []string
+	if user.UID != "" && current.UID != user.UID {
+		options = append(options, "-u", transport.Quote(user.UID))
+	}
+	if user.Shell != "" && current.Shell != user.Shell {
+		options = append(options, "-s", transport.Quote(user.Shell))
+	}
+	if user.Home != "" && current.Home != user.Home {
+		options = append(options, "-d", transport.Quote(user.Home), "-m")
+	}
+	if user.Group != "" {
+		fields, err := getent(t, "group", user.Group)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) < 3 || fields[2] != current.GID {
+			options = append(options, "-g", transport.Quote(user.Group))
+		}
+	}
+	if len(options) > 0 {
+		cmds = append(cmds, "sudo usermod "+strings.Join(options, " ")+" "+transport.Quote(user.Name))
+	}
+
+	if len(user.Groups) > 0 {
+		output, err := t.Run("id -nG " + transport.Quote(user.Name))
+		if err != nil {
+			return nil, err
+		}
+		member := make(map[string]bool)
+		for _, group := range strings.Fields(output) {
+			member[group] = true
+		}
+		var missing []string
+		for _, group := range user.Groups {
+			if !member[group] {
+				missing = append(missing, group)
+			}
+		}
+		if len(missing) > 0 {
+			cmds = append(cmds, "sudo usermod -a -G "+transport.Quote(strings.Join(missing, ","))+" "+transport.Quote(user.Name))
+		}
+	}
+
+	return cmds, nil
+}
+
+// Returns the command that creates a user along with its home folder
+func addUser(t transport.Transport, user User) ([]string, error) {
+	cmd := "sudo useradd -m"
+	if user.UID != "" {
+		cmd += " -u " + transport.Quote(user.UID)
+	}
+
+	// useradd refuses to create the group named after the user when it already exists, eg: from a [GROUPS] section
+	group := user.Group
+	if group == "" {
+		fields, err := getent(t, "group", user.Name)
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) >= 3 {
+			group = user.Name
+		}
+	}
+	if group != "" {
+		cmd += " -g " + transport.Quote(group)
+	}
+
+	if len(user.Groups) > 0 {
+		cmd += " -G " + transport.Quote(strings.Join(user.Groups, ","))
+	}
+	if user.Shell != "" {
+		cmd += " -s " + transport.Quote(user.Shell)
+	}
+	if user.Home != "" {
+		cmd += " -d " + transport.Quote(user.Home)
+	}
+	if user.System {
+		cmd += " -r"
+	}
+
+	return []string{cmd + " " + transport.Quote(user.Name)}, nil
+}
+
+// Reads an entry of a system database, eg: passwd or group, split on its colons. Returns no fields when there is no such entry.
+func getent(t transport.Transport, database, name string) ([]string, error) {
+	// getent exits with 2 when the entry is missing
+	output, err := t.Run("getent " + database + " " + transport.Quote(name) + " || true")
+	if err != nil {
+		return nil, err
+	}
+
+	output = strings.TrimSpace(output)
+	if output == "" {
+		return nil, nil
+	}
+	return strings.Split(strings.SplitN(output, "\n", 2)[0], ":"), nil
+}
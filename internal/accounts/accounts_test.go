@@ -0,0 +1,89 @@
+package accounts_test
+
+import (
+	"testing"
+
+	"github.com/praveensastry/cm/internal/accounts"
+	"github.com/praveensastry/cm/internal/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+// Answers getent and id the way a target with the given entries would
+func fakeSystem(entries map[string]string) *transport.Fake {
+	fake := transport.NewFake()
+	fake.Responder = func(cmd string) (string, error) {
+		return entries[cmd], nil
+	}
+	return fake
+}
+
+func TestGroupCommands(t *testing.T) {
+	fake := fakeSystem(map[string]string{
+		"getent group 'www' || true": "www:x:1001:\n",
+	})
+
+	cmds, err := accounts.GroupCommands(fake, accounts.Group{Name: "deploy", GID: "1500", System: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sudo groupadd -g '1500' -r 'deploy'"}, cmds)
+
+	cmds, err = accounts.GroupCommands(fake, accounts.Group{Name: "www", GID: "1002"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sudo groupmod -g '1002' 'www'"}, cmds)
+
+	cmds, err = accounts.GroupCommands(fake, accounts.Group{Name: "www", GID: "1001"})
+	assert.NoError(t, err)
+	assert.Empty(t, cmds)
+
+	cmds, err = accounts.GroupCommands(fake, accounts.Group{Name: "www"})
+	assert.NoError(t, err)
+	assert.Empty(t, cmds)
+}
+
+func TestUserCommandsNewUser(t *testing.T) {
+	fake := fakeSystem(nil)
+	cmds, err := accounts.UserCommands(fake, accounts.User{Name: "deploy", UID: "1500", Groups: []string{"www", "docker"}, Shell: "/bin/bash", Home: "/srv/deploy"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sudo useradd -m -u '1500' -G 'www,docker' -s '/bin/bash' -d '/srv/deploy' 'deploy'"}, cmds)
+
+	// The group named after the user already exists, eg: from a [GROUPS] section
+	fake = fakeSystem(map[string]string{
+		"getent group 'deploy' || true": "deploy:x:1500:\n",
+	})
+	cmds, err = accounts.UserCommands(fake, accounts.User{Name: "deploy", System: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sudo useradd -m -g 'deploy' -r 'deploy'"}, cmds)
+}
+
+func TestUserCommandsExistingUser(t *testing.T) {
+	fake := fakeSystem(map[string]string{
+		"getent passwd 'deploy' || true": "deploy:x:1500:1500::/home/deploy:/bin/sh\n",
+		"getent group 'deploy' || true":  "deploy:x:1500:\n",
+		"getent group 'www' || true":     "www:x:1001:deploy\n",
+		"id -nG 'deploy'":                "deploy www\n",
+	})
+	existing := accounts.User{Name: "deploy", UID: "1500", Group: "deploy", Groups: []string{"www"}, Shell: "/bin/sh", Home: "/home/deploy"}
+
+	cmds, err := accounts.UserCommands(fake, existing)
+	assert.NoError(t, err)
+	assert.Empty(t, cmds)
+
+	cases := []struct {
+		change func(*accounts.User)
+		want   []string
+	}{
+		{func(u *accounts.User) { u.Shell = "/bin/bash" }, []string{"sudo usermod -s '/bin/bash' 'deploy'"}},
+		{func(u *accounts.User) { u.Home = "/srv/deploy" }, []string{"sudo usermod -d '/srv/deploy' -m 'deploy'"}},
+		{func(u *accounts.User) { u.UID = "1600" }, []string{"sudo usermod -u '1600' 'deploy'"}},
+		{func(u *accounts.User) { u.Group = "www" }, []string{"sudo usermod -g 'www' 'deploy'"}},
+		{func(u *accounts.User) { u.Groups = []string{"www", "docker", "adm"} }, []string{"sudo usermod -a -G 'docker,adm' 'deploy'"}},
+		{func(u *accounts.User) { u.Shell, u.Groups = "/bin/bash", []string{"docker"} }, []string{"sudo usermod -s '/bin/bash' 'deploy'", "sudo usermod -a -G 'docker' 'deploy'"}},
+	}
+
+	for _, c := range cases {
+		user := existing
+		c.change(&user)
+		cmds, err := accounts.UserCommands(fake, user)
+		assert.NoError(t, err)
+		assert.Equal(t, c.want, cmds)
+	}
+}
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"os/user"
+	"sort"
+	"strings"
 
 	"github.com/praveensastry/cm/internal/servers"
 	"github.com/praveensastry/cm/terminal"
@@ -10,17 +12,27 @@ import (
 	"gopkg.in/ini.v1"
 )
 
+// Host and group keys that set a variable, eg: var.http_port = 8080
+const varPrefix = "var."
+
+// Sections that declare a group of hosts rather than a host, eg: [GROUP.web]
+const groupPrefix = "GROUP."
+
 type CMConfig struct {
 	Servers servers.Servers
+	Groups  servers.Groups
 }
 
 // Reads in the config and returns a CMConfig struct
 func ReadConfig() (*CMConfig, error) {
+	currentUser, _ := user.Current()
+	return ReadConfigFile(currentUser.HomeDir + "/.cminventory")
+}
 
-	config := new(CMConfig)
+// Reads in the config from a given file, and works out the groups of each server
+func ReadConfigFile(configLocation string) (*CMConfig, error) {
 
-	currentUser, _ := user.Current()
-	configLocation := currentUser.HomeDir + "/.cminventory"
+	config := new(CMConfig)
 
 	cfg, err := ini.Load(configLocation)
 	if err != nil {
@@ -36,6 +48,17 @@ func ReadConfig() (*CMConfig, error) {
 			continue
 		}
 
+		if strings.HasPrefix(remote.Name(), groupPrefix) {
+			group := new(servers.Group)
+			if err := remote.MapTo(group); err != nil {
+				return config, err
+			}
+			group.Name = strings.TrimPrefix(remote.Name(), groupPrefix)
+			group.Vars = readVars(remote)
+			config.Groups = append(config.Groups, *group)
+			continue
+		}
+
 		server := new(servers.Server)
 
 		err := remote.MapTo(server)
@@ -44,10 +67,37 @@ func ReadConfig() (*CMConfig, error) {
 		}
 
 		server.Name = remote.Name()
+		server.Vars = readVars(remote)
 		config.Servers = append(config.Servers, *server)
 	}
 
-	return config, err
+	return config, servers.ResolveGroups(config.Servers, config.Groups)
+}
+
+// Reads the var.<name> keys of a host or group
+func readVars(section *ini.Section) map[string]string {
+	var vars map[string]string
+	for _, key := range section.Keys() {
+		if name := strings.TrimPrefix(key.Name(), varPrefix); name != key.Name() {
+			if vars == nil {
+				vars = make(map[string]string)
+			}
+			vars[name] = key.String()
+		}
+	}
+	return vars
+}
+
+// Writes out the var.<name> keys of a host or group, in name order
+func writeVars(section *ini.Section, vars map[string]string) {
+	var names []string
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		section.NewKey(varPrefix+name, vars[name])
+	}
 }
 
 // Interactive new server setup
@@ -66,9 +116,12 @@ func (c *CMConfig) AddServer() error {
 
 // Save our list of servers into the config file
 func (c *CMConfig) SaveConfig() error {
-
 	currentUser, _ := user.Current()
-	configLocation := currentUser.HomeDir + "/.cminventory"
+	return c.SaveConfigFile(currentUser.HomeDir + "/.cminventory")
+}
+
+// Save our list of servers and groups into a given file
+func (c *CMConfig) SaveConfigFile(configLocation string) error {
 
 	cfg := ini.Empty()
 
@@ -80,6 +133,20 @@ func (c *CMConfig) SaveConfig() error {
 
 		// Hack to get bools to play nice, and not just output "<bool Value>" - I'll probably open a pull request once I track down the issue.
 		cfg.Section(server.Name).NewKey("PassAuth", fmt.Sprintf("%t", server.PassAuth))
+
+		// A spec that comes from a group belongs to the group
+		if server.SpecFrom != "" {
+			cfg.Section(server.Name).DeleteKey("Spec")
+		}
+		writeVars(cfg.Section(server.Name), server.Vars)
+	}
+
+	for _, group := range c.Groups {
+		section := cfg.Section(groupPrefix + group.Name)
+		if err := section.ReflectFrom(&group); err != nil {
+			return err
+		}
+		writeVars(section, group.Vars)
 	}
 
 	err := cfg.SaveToIndent(configLocation, "\t")
@@ -102,6 +169,7 @@ func (c *CMConfig) DeleteServer() error {
 
 	sure := terminal.PromptBool("Are you sure you want to delete this server?")
 	if sure {
+		c.Groups.RemoveHost(c.Servers[index].Name)
 		c.Servers, c.Servers[len(c.Servers)-1] = append(c.Servers[:index], c.Servers[index+1:]...), servers.Server{}
 		return c.SaveConfig()
 	}
@@ -117,6 +185,9 @@ func (c *CMConfig) addServerDialog() {
 	passAuth := terminal.PromptBool(fmt.Sprintf("Does [%s] require password authentication?", name))
 
 	server := servers.New(name, host, username, spec, passAuth)
+	if terminal.PromptBool(fmt.Sprintf("Do you want to use an identity file for [%s]? (keys in your ssh-agent are always offered)", name)) {
+		server.IdentityFile = []string{terminal.PromptString(fmt.Sprintf("What is the path of the identity file for [%s]?", name))}
+	}
 	server.PrintServerInfo()
 
 	correct := terminal.PromptBool("Great! Does that look correct?")
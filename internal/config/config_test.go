@@ -1,9 +1,14 @@
 package config_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/praveensastry/cm/internal/config"
+	"github.com/praveensastry/cm/internal/parser"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,3 +19,77 @@ func TestConfigRead(t *testing.T) {
 
 	assert.NotPanics(t, getCfg)
 }
+
+func TestConfigGroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-config")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	inventory := `[web01]
+	Host = 10.0.0.1
+	Username = deploy
+	var.http_port = 8080
+
+[db01]
+	Host = 10.0.0.2
+	Username = deploy
+	Spec = postgres
+
+[GROUP.web]
+	Hosts = web01
+	Spec = site
+	var.http_port = 80
+	var.workers = 4
+
+[GROUP.db]
+	Hosts = db01
+	Spec = mysql
+
+[GROUP.prod]
+	Groups = web, db
+	Spec = base
+	var.workers = 2
+	var.env = prod
+`
+	file := filepath.Join(dir, "inventory")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(inventory), 0644))
+
+	cfg, err := config.ReadConfigFile(file)
+	assert.NoError(t, err)
+	assert.Len(t, cfg.Groups, 3)
+
+	web, db := cfg.Servers[0], cfg.Servers[1]
+	assert.Equal(t, []string{"web", "prod"}, web.Groups)
+	assert.Equal(t, "site", web.Spec)
+	assert.Equal(t, "web", web.SpecFrom)
+	assert.Equal(t, "postgres", db.Spec)
+	assert.Empty(t, db.SpecFrom)
+
+	var sources []string
+	for _, layer := range web.VarLayers() {
+		sources = append(sources, layer.Source)
+	}
+	assert.Equal(t, []string{"group prod", "group web", "host web01"}, sources)
+	vars := parser.VarValues(parser.ResolveVars(web.VarLayers()...))
+	assert.Equal(t, map[string]string{"http_port": "8080", "workers": "4", "env": "prod"}, vars)
+
+	assert.Len(t, cfg.Servers.Matching("prod"), 2)
+	assert.Len(t, cfg.Servers.Matching("web"), 1)
+
+	// Groups and vars survive a save, and group specs stay with the group
+	saved := filepath.Join(dir, "saved")
+	assert.NoError(t, cfg.SaveConfigFile(saved))
+	again, err := config.ReadConfigFile(saved)
+	assert.NoError(t, err)
+	assert.Equal(t, cfg.Groups, again.Groups)
+	assert.Equal(t, cfg.Servers, again.Servers)
+	contents, err := ioutil.ReadFile(saved)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(contents), "= site"))
+
+	cycle := inventory + "\n[GROUP.web2]\n\tGroups = prod\n"
+	cycle = strings.Replace(cycle, "Groups = web, db", "Groups = web, db, web2", 1)
+	assert.NoError(t, ioutil.WriteFile(file, []byte(cycle), 0644))
+	_, err = config.ReadConfigFile(file)
+	assert.EqualError(t, err, "inventory groups form a cycle: prod -> web2 -> prod")
+}
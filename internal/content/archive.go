@@ -0,0 +1,183 @@
+package content
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The files of an archive, unpacked into the cache
+type Unpacked struct {
+	Dir    string // the unpacked files
+	Sha256 string // the checksum of the archive
+}
+
+// Unpacks a local tarball or zip into the cache, after checking it against a checksum when one is
+// given. Archives are unpacked once per checksum, later runs reuse the unpacked files.
+func FetchArchive(cacheDir, archive, sum string) (*Unpacked, error) {
+	actual, err := fileChecksum(archive)
+	if err != nil {
+		return nil, err
+	}
+	if sum != "" && !strings.EqualFold(sum, actual) {
+		return nil, fmt.Errorf("checksum mismatch for %s, expected sha256 %s but got %s", archive, sum, actual)
+	}
+
+	return unpack(cacheDir, archive, actual)
+}
+
+// Unpacks an archive into the folder of its checksum, the format is told by its contents
+func unpack(cacheDir, archive, sum string) (*Unpacked, error) {
+	unpacked := &Unpacked{Dir: filepath.Join(cacheDir, "archives", sum), Sha256: sum}
+
+	err := fill(unpacked.Dir, func(tmp string) error {
+		f, err := os.Open(archive)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		header := make([]byte, 512)
+		n, _ := io.ReadFull(f, header)
+		header = header[:n]
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		switch {
+		case bytes.HasPrefix(header, []byte("PK\x03\x04")):
+			return unzip(archive, tmp)
+		case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+			gz, err := gzip.NewReader(bufio.NewReader(f))
+			if err != nil {
+				return err
+			}
+			defer gz.Close()
+			return untar(gz, tmp)
+		case len(header) > 262 && string(header[257:262]) == "ustar":
+			return untar(f, tmp)
+		}
+		return fmt.Errorf("%s isn't a tarball or zip", archive)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return unpacked, nil
+}
+
+// Unpacks the folders and regular files of a tarball, links and devices are left out
+func untar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := within(dir, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := writeFile(target, tr, header.FileInfo().Mode()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Unpacks the folders and files of a zip
+func unzip(archive, dir string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, file := range zr.File {
+		target, err := within(dir, file.Name)
+		if err != nil {
+			return err
+		}
+
+		if file.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if !file.Mode().IsRegular() {
+			continue
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return err
+		}
+		err = writeFile(target, rc, file.Mode())
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Joins the name of an archive entry to the folder it is unpacked into, refusing names that would escape it
+func within(dir, name string) (string, error) {
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	if target != dir && !strings.HasPrefix(target, dir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("archive entry [%s] is outside of the archive", name)
+	}
+	return target, nil
+}
+
+// Writes a file of an archive, keeping its permission bits
+func writeFile(target string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Returns the hex sha256 checksum of a file
+func fileChecksum(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
@@ -0,0 +1,54 @@
+package content
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+)
+
+// Returns the folder remote content is fetched into, ~/.cmcache
+func CacheDir() string {
+	if currentUser, err := user.Current(); err == nil {
+		return filepath.Join(currentUser.HomeDir, ".cmcache")
+	}
+	return filepath.Join(os.TempDir(), "cmcache")
+}
+
+// Names a cache entry after what it holds, so the same source always lands in the same place
+func cacheKey(source string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(source)))[:16]
+}
+
+// Fills in a folder of the cache by writing to a temporary folder next to it, and moving it into
+// place once complete, so that an interrupted fetch never leaves a partial entry behind.
+// Does nothing when the folder is already there.
+func fill(dir string, write func(tmp string) error) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(filepath.Dir(dir), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := write(tmp); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, dir); err != nil {
+		// Another run filled it in first
+		if _, statErr := os.Stat(dir); statErr == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
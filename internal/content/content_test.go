@@ -0,0 +1,206 @@
+package content_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/praveensastry/cm/internal/content"
+	"github.com/stretchr/testify/assert"
+)
+
+// Runs git in a folder, with an identity so that commits work anywhere
+func run(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=cm", "GIT_AUTHOR_EMAIL=cm@example.com",
+		"GIT_COMMITTER_NAME=cm", "GIT_COMMITTER_EMAIL=cm@example.com")
+	output, err := cmd.CombinedOutput()
+	assert.NoError(t, err, string(output))
+	return strings.TrimSpace(string(output))
+}
+
+// Commits a file to a repository and returns the commit
+func commit(t *testing.T, repo, name, contents string) string {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(repo, name)), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(repo, name), []byte(contents), 0644))
+	run(t, repo, "add", "-A")
+	run(t, repo, "commit", "-q", "-m", "update "+name)
+	return run(t, repo, "rev-parse", "HEAD")
+}
+
+func TestFetchGit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-content")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "site")
+	cache := filepath.Join(dir, "cache")
+	assert.NoError(t, os.MkdirAll(repo, 0755))
+	run(t, repo, "init", "-q", "-b", "main")
+	first := commit(t, repo, "public/index.html", "v1\n")
+	run(t, repo, "tag", "v1")
+
+	checkout, err := content.FetchGit(cache, repo, "")
+	assert.NoError(t, err)
+	assert.Equal(t, first, checkout.Commit)
+	index, err := ioutil.ReadFile(filepath.Join(checkout.Dir, "public", "index.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1\n", string(index))
+	_, err = os.Stat(filepath.Join(checkout.Dir, ".git"))
+	assert.True(t, os.IsNotExist(err), "the checkout holds the files only")
+
+	// The next fetch picks up new commits, while a tag stays where it was
+	second := commit(t, repo, "public/index.html", "v2\n")
+	checkout, err = content.FetchGit(cache, "file://"+repo, "main")
+	assert.NoError(t, err)
+	assert.Equal(t, second, checkout.Commit)
+
+	checkout, err = content.FetchGit(cache, "file://"+repo, "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, first, checkout.Commit)
+	index, err = ioutil.ReadFile(filepath.Join(checkout.Dir, "public", "index.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1\n", string(index))
+
+	_, err = content.FetchGit(cache, repo, "no-such-branch")
+	assert.Error(t, err)
+	_, err = content.FetchGit(cache, filepath.Join(dir, "missing"), "")
+	assert.Error(t, err)
+
+	// Once the repository is gone, a cached commit needs no fetch, and a branch falls back on the cached clone
+	assert.NoError(t, os.Rename(repo, repo+"-moved"))
+	checkout, err = content.FetchGit(cache, repo, first)
+	assert.NoError(t, err)
+	assert.Equal(t, first, checkout.Commit)
+	assert.Nil(t, checkout.Stale)
+
+	checkout, err = content.FetchGit(cache, repo, "main")
+	assert.NoError(t, err)
+	assert.Equal(t, second, checkout.Commit)
+	assert.Error(t, checkout.Stale)
+	assert.Contains(t, checkout.Origin(repo), "(cached, unable to fetch: ")
+}
+
+// Builds a gzipped tarball of the given files
+func tarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, contents := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(contents))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func checksum(b []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+func TestFetchArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-content")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cache := filepath.Join(dir, "cache")
+
+	// A tarball, checked against its checksum
+	archive := tarball(t, map[string]string{"site-1.0/public/index.html": "v1\n"})
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "site.tar.gz"), archive, 0644))
+
+	unpacked, err := content.FetchArchive(cache, filepath.Join(dir, "site.tar.gz"), checksum(archive))
+	assert.NoError(t, err)
+	assert.Equal(t, checksum(archive), unpacked.Sha256)
+	index, err := ioutil.ReadFile(filepath.Join(unpacked.Dir, "site-1.0", "public", "index.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v1\n", string(index))
+
+	_, err = content.FetchArchive(cache, filepath.Join(dir, "site.tar.gz"), checksum([]byte("something else")))
+	assert.Error(t, err)
+
+	// A zip, without a checksum
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("index.html")
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("zipped\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "site.zip"), buf.Bytes(), 0644))
+
+	unpacked, err = content.FetchArchive(cache, filepath.Join(dir, "site.zip"), "")
+	assert.NoError(t, err)
+	index, err = ioutil.ReadFile(filepath.Join(unpacked.Dir, "index.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, "zipped\n", string(index))
+
+	// Entries can't escape the folder they are unpacked into
+	evil := tarball(t, map[string]string{"../../escaped": "gotcha\n"})
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "evil.tar.gz"), evil, 0644))
+	_, err = content.FetchArchive(cache, filepath.Join(dir, "evil.tar.gz"), "")
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(cache, "escaped"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Anything else isn't an archive
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "notes.txt"), []byte("hello\n"), 0644))
+	_, err = content.FetchArchive(cache, filepath.Join(dir, "notes.txt"), "")
+	assert.Error(t, err)
+}
+
+func TestFetchHTTP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-content")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	cache := filepath.Join(dir, "cache")
+
+	archive := tarball(t, map[string]string{"index.html": "downloaded\n"})
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/site.tar.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		downloads++
+		w.Write(archive)
+	}))
+	defer server.Close()
+
+	_, err = content.FetchHTTP(cache, server.URL+"/site.tar.gz", "")
+	assert.Error(t, err, "the checksum is required")
+
+	_, err = content.FetchHTTP(cache, server.URL+"/site.tar.gz", checksum([]byte("something else")))
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(cache, "downloads", checksum([]byte("something else"))))
+	assert.True(t, os.IsNotExist(err), "a download that doesn't match isn't kept")
+
+	_, err = content.FetchHTTP(cache, server.URL+"/missing.tar.gz", checksum(archive))
+	assert.Error(t, err)
+
+	unpacked, err := content.FetchHTTP(cache, server.URL+"/site.tar.gz", checksum(archive))
+	assert.NoError(t, err)
+	index, err := ioutil.ReadFile(filepath.Join(unpacked.Dir, "index.html"))
+	assert.NoError(t, err)
+	assert.Equal(t, "downloaded\n", string(index))
+
+	// Cached downloads aren't downloaded again
+	before := downloads
+	_, err = content.FetchHTTP(cache, server.URL+"/site.tar.gz", checksum(archive))
+	assert.NoError(t, err)
+	assert.Equal(t, before, downloads)
+}
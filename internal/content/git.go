@@ -0,0 +1,123 @@
+package content
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Content checked out of a git repository at a commit
+type Checkout struct {
+	Dir    string // the files of the commit
+	Commit string
+	Stale  error // why the repository couldn't be fetched, when the cached clone was used instead
+}
+
+// A full commit id, which never moves, unlike branches and tags
+var commitPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// Clones or fetches a git repository into the cache, and checks out a ref: a branch, tag or commit,
+// the default branch when empty. Every commit is checked out into a folder of its own, which is
+// reused by later runs at the same commit. A full commit that is already cached isn't fetched again,
+// and when fetching fails, the cached clone is used as it is, see Checkout.Stale.
+func FetchGit(cacheDir, repo, ref string) (*Checkout, error) {
+	repoDir := filepath.Join(cacheDir, "git", cacheKey(repo))
+	var stale error
+
+	if _, err := os.Stat(filepath.Join(repoDir, "HEAD")); err != nil {
+		if err := fill(repoDir, func(tmp string) error {
+			_, err := git("", "clone", "--bare", "--quiet", repo, tmp)
+			return err
+		}); err != nil {
+			return nil, err
+		}
+	} else if !commitPattern.MatchString(ref) || !hasCommit(repoDir, ref) {
+		if _, err := git(repoDir, "fetch", "--quiet", "--force", "--tags", "origin", "+refs/heads/*:refs/heads/*"); err != nil {
+			stale = err
+		}
+	}
+
+	commit, err := resolveRef(repoDir, ref)
+	if err != nil {
+		if stale != nil {
+			return nil, stale
+		}
+		return nil, err
+	}
+
+	checkout := &Checkout{Dir: filepath.Join(cacheDir, "git", cacheKey(repo)+"-"+commit), Commit: commit, Stale: stale}
+	err = fill(checkout.Dir, func(tmp string) error {
+		_, err := git(repoDir, "--work-tree="+tmp, "checkout", "--force", commit, "--", ".")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return checkout, nil
+}
+
+// Describes where the files of the checkout came from, eg: for the origin of a spec
+func (c *Checkout) Origin(repo string) string {
+	origin := repo + " at " + c.Commit
+	if c.Stale != nil {
+		origin += " (cached, unable to fetch: " + c.Stale.Error() + ")"
+	}
+	return origin
+}
+
+// Checks whether a bare clone already holds a commit
+func hasCommit(repoDir, commit string) bool {
+	_, err := git(repoDir, "cat-file", "-e", commit+"^{commit}")
+	return err == nil
+}
+
+// Resolves a ref of a bare clone to the commit it points at
+func resolveRef(repoDir, ref string) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	commit, err := git(repoDir, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("unable to find ref [%s] in the repository", ref)
+	}
+	return commit, nil
+}
+
+// Runs git, in a repository when one is given, and returns its trimmed output
+func git(repoDir string, args ...string) (string, error) {
+	if repoDir != "" {
+		args = append([]string{"--git-dir=" + repoDir}, args...)
+	}
+
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = err.Error()
+		}
+		return "", fmt.Errorf("git %s: %s", subcommand(args), message)
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// Returns the git subcommand among its arguments, eg: clone
+func subcommand(args []string) string {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			return arg
+		}
+	}
+	return ""
+}
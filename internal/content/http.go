@@ -0,0 +1,73 @@
+package content
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Client downloads content, with a timeout so a stalled server doesn't hang a run
+var Client = &http.Client{Timeout: 10 * time.Minute}
+
+// Downloads a tarball or zip into the cache, checks it against its sha256 checksum, which is
+// required, and unpacks it. A download that is already in the cache isn't downloaded again.
+func FetchHTTP(cacheDir, url, sum string) (*Unpacked, error) {
+	sum = strings.ToLower(sum)
+	if len(sum) != 64 {
+		return nil, fmt.Errorf("a sha256 checksum is required to download %s", url)
+	}
+
+	download := filepath.Join(cacheDir, "downloads", sum)
+	if actual, err := fileChecksum(download); err != nil || actual != sum {
+		if err := fetchURL(url, download, sum); err != nil {
+			return nil, err
+		}
+	}
+
+	return unpack(cacheDir, download, sum)
+}
+
+// Downloads a URL to a file, only keeping it when it matches the checksum
+func fetchURL(url, file, sum string) error {
+	resp, err := Client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to download %s: %s", url, resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to download %s: %s", url, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	actual, err := fileChecksum(tmp.Name())
+	if err != nil {
+		return err
+	}
+	if actual != sum {
+		return fmt.Errorf("checksum mismatch for %s, expected sha256 %s but got %s", url, sum, actual)
+	}
+
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,865 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/hil"
+	"github.com/hashicorp/hil/ast"
+	"github.com/praveensastry/cm/internal/accounts"
+	"github.com/praveensastry/cm/internal/content"
+	"github.com/praveensastry/cm/internal/packages"
+	"github.com/praveensastry/cm/internal/parser"
+	"github.com/praveensastry/cm/internal/services"
+	"github.com/praveensastry/cm/internal/transport"
+	"github.com/praveensastry/cm/terminal"
+)
+
+// Configures a single target with a spec. The job only talks to its target through
+// the Transport, so remote and local runs share every step.
+type Job struct {
+	Name      string // how the target is labelled in the output
+	Transport transport.Transport
+	SpecList  *parser.SpecList
+	SpecName  string
+	Vars      map[string]string // built in interpolation variables, files and commands are used as-is without them
+	VarLayers []parser.VarLayer // variables that override the [VARS] of the specs, lowest precedence first
+	Responses chan string
+	Errors    chan error
+
+	ConfirmEach bool // ask before overwriting each existing file that would change
+	Flat        bool // run every pre-configure command, then every package and so on across the whole tree, instead of one spec at a time
+	Offline     bool // go ahead with cached clones of specs and content that couldn't be fetched
+
+	PackageManager *packages.Manager    // detected from the target's /etc/os-release when not set
+	InitSystem     *services.InitSystem // detected from the target when not set
+	CacheDir       string               // where remote content is fetched to, ~/.cmcache when empty
+
+	Summary Summary // what the job did, filled in as it runs
+
+	values  map[string]string // every interpolation variable, see resolveVars
+	changed []string          // destinations of the files written by this run
+	spec    string            // the spec being applied, added to the output
+	started []string          // services started by this run, which don't need a reload
+}
+
+// Tally of the tasks a job ran against its target
+type Summary struct {
+	Changed  int // files written, and commands run because their guards or a changed file called for it
+	OK       int // files, packages, accounts and services that were already as wanted, and commands their guards skipped
+	Ran      int // commands without guards, which run every time and may or may not change anything
+	Skipped  int // files that differ, but were left alone when declined under ConfirmEach
+	Failed   int
+	Services []ServiceResult // what was done to each service
+}
+
+// What a job did to a service
+type ServiceResult struct {
+	Name    string
+	Actions []string // eg: start, enable, reload; empty when it was already as wanted
+}
+
+// Jobs run in parallel, but only one of them can show a diff or ask a question at a time
+var interactive sync.Mutex
+
+// Builds the interpolation variables for a target, available in templates as ${var.class} and so on
+func NewVars(specName, class, sequence, locale string) map[string]string {
+	return map[string]string{
+		"class":    class,
+		"sequence": sequence,
+		"locale":   locale,
+		"specname": specName,
+	}
+}
+
+// Runs the job and returns results on the job channels
+func (job *Job) Run() error {
+	job.changed, job.spec, job.started = nil, "", nil
+	job.values = job.resolveVars()
+
+	// Make sure the requirements of the spec can be met before touching anything
+	if _, err := job.SpecList.Resolve(job.SpecName); err != nil {
+		return job.fail("Unable to resolve the requirements of spec ["+job.SpecName+"]! Aborting futher tasks for this server..", err)
+	}
+
+	// Fetch remote content before touching anything, so a missing repository doesn't leave the target half configured
+	if err := job.prepareContent(); err != nil {
+		return job.fail("Unable to fetch content! Aborting futher tasks for this server..", err)
+	}
+
+	// Elevate permissions
+	job.respond("*", "Attempting to elevate permissions...")
+	if _, err := job.Transport.Run("sudo uname"); err != nil {
+		return job.fail("Permission Elevation Failed! Aborting futher tasks for this server..", err)
+	}
+	job.respond("✓", "Permission Elevation Succeeded!")
+
+	// Apply each spec in turn, or the whole tree at once when flattened
+	lifecycles := job.SpecList.Lifecycles(job.SpecName)
+	if job.Flat {
+		lifecycles = []parser.Lifecycle{job.SpecList.FlatLifecycle(job.SpecName)}
+	}
+	for _, lifecycle := range lifecycles {
+		if err := job.runLifecycle(lifecycle); err != nil {
+			return err
+		}
+	}
+	job.spec = ""
+
+	// Run the handlers watching the files that changed
+	for _, handler := range job.SpecList.Handlers(job.SpecName) {
+		if !handler.Notified(job.changed) {
+			continue
+		}
+		for _, cmd := range handler.Commands {
+			cmd, err := job.interpolateCommand(cmd)
+			if err != nil {
+				return job.fail("Handler ["+handler.Name+"] is invalid! Aborting futher tasks for this server..", err)
+			}
+			job.respond("*", "Running Handler ["+handler.Name+"]: ["+cmd+"]...")
+			if _, err := job.Transport.Run(cmd); err != nil {
+				return job.fail("Handler ["+handler.Name+"] Failed! Aborting futher tasks for this server..", err)
+			}
+			job.Summary.Changed++
+			job.respond("✓", "Handler ["+handler.Name+"] Succeeded!")
+		}
+	}
+
+	// Reload or restart the services watching the files that changed
+	for _, service := range job.SpecList.Services(job.SpecName) {
+		if !service.Notified(job.changed) || service.State == "stopped" || contains(job.started, service.Name) {
+			continue
+		}
+		if err := job.serviceAction(service.ChangeAction(), service.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Runs the pre-configure commands, packages, files and post-configure commands of a lifecycle.
+// Output is labelled with the spec the lifecycle belongs to.
+func (job *Job) runLifecycle(lifecycle parser.Lifecycle) error {
+	job.spec = lifecycle.Name
+
+	for _, origin := range lifecycle.Origins {
+		job.respond("=", "Content from "+origin)
+	}
+
+	// Run pre configure commands
+	for _, preCmd := range lifecycle.PreCmds {
+		if err := job.runCommand("Pre-Configuration", preCmd); err != nil {
+			return err
+		}
+	}
+
+	// Bring the packages to the state the spec wants, with the package manager of the target
+	if lifecycle.HasPackages() {
+		if err := job.applyPackages(lifecycle); err != nil {
+			return err
+		}
+	}
+
+	// Create the groups and users before the files, so that files can be owned by them
+	if err := job.applyAccounts(lifecycle); err != nil {
+		return err
+	}
+
+	// Transfer any files we need to transfer
+	if len(*lifecycle.Transfers) > 0 {
+		job.respond("*", "Starting file transfer...")
+		if err := job.transferFiles(lifecycle.Transfers); err != nil {
+			return job.fail("File Transfer Failed! Aborting futher tasks for this server..", err)
+		}
+		job.respond("✓", "File Transfer Succeeded!")
+	}
+
+	// Bring the services to their state, so the post configure commands can rely on them
+	if err := job.applyServices(lifecycle.Services); err != nil {
+		return err
+	}
+
+	// Run post configure commands
+	for _, postCmd := range lifecycle.PostCmds {
+		if err := job.runCommand("Post-Configuration", postCmd); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Runs a pre or post-configure command, unless its guards say it isn't needed
+func (job *Job) runCommand(kind, entry string) error {
+	entry, err := job.interpolateCommand(entry)
+	if err != nil {
+		return job.fail(kind+" Command is invalid! Aborting futher tasks for this server..", err)
+	}
+	command, err := parser.ParseCommand(entry)
+	if err != nil {
+		return job.fail(kind+" Command is invalid! Aborting futher tasks for this server..", err)
+	}
+
+	reason, err := job.skipReason(command)
+	if err != nil {
+		return job.fail("Unable to check "+kind+" Command ["+command.Run+"]! Aborting futher tasks for this server..", err)
+	}
+	if reason != "" {
+		job.Summary.OK++
+		job.respond("-", "Skipped "+kind+" Command: ["+command.Run+"], "+reason)
+		return nil
+	}
+
+	job.respond("*", "Running "+kind+" Command: ["+command.Run+"]...")
+	if _, err := job.Transport.Run(commandShell(command)); err != nil {
+		return job.fail(kind+" Command Failed! Aborting futher tasks for this server..", err)
+	}
+	if command.Guarded() {
+		job.Summary.Changed++
+	} else {
+		job.Summary.Ran++
+	}
+	job.respond("✓", kind+" Command Succeeded!")
+
+	return nil
+}
+
+// Evaluates the guards of a command on the target, and returns why it should be skipped, or nothing when it should run.
+// A check that exits with an error counts as failed.
+func (job *Job) skipReason(command parser.Command) (string, error) {
+	if command.Creates != "" {
+		info, err := job.Transport.Stat(command.Creates)
+		if err != nil {
+			return "", err
+		}
+		if info.Exists {
+			return command.Creates + " exists", nil
+		}
+	}
+
+	if command.Unless != "" {
+		if _, err := job.Transport.Run(inDir(command.Dir, command.Unless)); err == nil {
+			return "[" + command.Unless + "] succeeded", nil
+		}
+	}
+
+	if command.OnlyIf != "" {
+		if _, err := job.Transport.Run(inDir(command.Dir, command.OnlyIf)); err != nil {
+			return "[" + command.OnlyIf + "] failed", nil
+		}
+	}
+
+	return "", nil
+}
+
+// Returns the shell command that runs a command on the target, in its folder and within its timeout
+func commandShell(command parser.Command) string {
+	cmd := command.Run
+	if command.Timeout > 0 {
+		seconds := int((command.Timeout + time.Second - 1) / time.Second)
+		cmd = "timeout " + strconv.Itoa(seconds) + " sh -c " + transport.Quote(cmd)
+	}
+	return inDir(command.Dir, cmd)
+}
+
+// Prefixes a shell command with a change of folder, when there is one
+func inDir(dir, cmd string) string {
+	if dir == "" {
+		return cmd
+	}
+	return "cd " + transport.Quote(dir) + " && " + cmd
+}
+
+// Installs, upgrades, removes and holds the packages of a lifecycle, skipping the ones already as wanted
+func (job *Job) applyPackages(lifecycle parser.Lifecycle) error {
+	manager, err := job.packageManager()
+	if err != nil {
+		return job.fail("Unable to detect the package manager! Aborting futher tasks for this server..", err)
+	}
+
+	state := packages.State{Install: lifecycle.Packages[manager.Name()], Absent: lifecycle.Absent, Held: lifecycle.Held}
+	if len(state.Install) == 0 && len(lifecycle.Packages) > 0 {
+		job.respond("-", "No packages listed for "+manager.Name()+", skipping them")
+	}
+
+	changes, err := manager.Compare(job.Transport, state)
+	if err != nil {
+		return job.fail("Unable to query packages! Aborting futher tasks for this server..", err)
+	}
+	job.Summary.OK += changes.Unchanged
+
+	if changes.Empty() {
+		job.respond("=", "Packages are up to date")
+		return nil
+	}
+
+	for _, cmd := range manager.Commands(changes) {
+		job.respond("*", "Running "+manager.Name()+" Command: ["+cmd+"]...")
+		if _, err := job.Transport.Run(cmd); err != nil {
+			return job.fail("Command "+manager.Name()+" Failed! Aborting futher tasks for this server..", err)
+		}
+		job.Summary.Changed++
+		job.respond("✓", "Command "+manager.Name()+" Succeeded!")
+	}
+
+	return nil
+}
+
+// Creates and updates the groups and then the users of a lifecycle, skipping the ones already as wanted,
+// and writes the authorized keys of the users
+func (job *Job) applyAccounts(lifecycle parser.Lifecycle) error {
+	for _, group := range lifecycle.Groups {
+		cmds, err := accounts.GroupCommands(job.Transport, accountGroup(group))
+		if err != nil {
+			return job.fail("Unable to query group ["+group.Name+"]! Aborting futher tasks for this server..", err)
+		}
+		if err := job.runAccountCommands("Group", group.Name, cmds); err != nil {
+			return err
+		}
+	}
+
+	for _, user := range lifecycle.Users {
+		cmds, err := accounts.UserCommands(job.Transport, accountUser(user))
+		if err != nil {
+			return job.fail("Unable to query user ["+user.Name+"]! Aborting futher tasks for this server..", err)
+		}
+		if err := job.runAccountCommands("User", user.Name, cmds); err != nil {
+			return err
+		}
+
+		if len(user.AuthorizedKeys) == 0 {
+			continue
+		}
+		file, err := job.authorizedKeys(user)
+		if err != nil {
+			return job.fail("Unable to read the authorized keys of user ["+user.Name+"]! Aborting futher tasks for this server..", err)
+		}
+		if err := job.transferFiles(&parser.FileTransfers{file}); err != nil {
+			return job.fail("Unable to write the authorized keys of user ["+user.Name+"]! Aborting futher tasks for this server..", err)
+		}
+	}
+
+	return nil
+}
+
+// Runs the commands that bring a group or user to its state, kind is Group or User
+func (job *Job) runAccountCommands(kind, name string, cmds []string) error {
+	if len(cmds) == 0 {
+		job.Summary.OK++
+		job.respond("=", kind+" ["+name+"] is already as wanted")
+		return nil
+	}
+
+	for _, cmd := range cmds {
+		job.respond("*", "Running "+kind+" Command: ["+cmd+"]...")
+		if _, err := job.Transport.Run(cmd); err != nil {
+			return job.fail(kind+" ["+name+"] Failed! Aborting futher tasks for this server..", err)
+		}
+		job.Summary.Changed++
+		job.respond("✓", kind+" ["+name+"] Succeeded!")
+	}
+
+	return nil
+}
+
+// Builds the authorized_keys file of a user, in the home folder the user has on the target
+func (job *Job) authorizedKeys(user parser.User) (parser.FileTransfer, error) {
+	var file parser.FileTransfer
+
+	contents, err := user.AuthorizedKeysFile()
+	if err != nil {
+		return file, err
+	}
+
+	home := user.HomeDir()
+	if user.Home == "" {
+		current, err := accounts.LookupUser(job.Transport, user.Name)
+		if err != nil {
+			return file, err
+		}
+		if current != nil {
+			home = current.Home
+		}
+	}
+
+	return parser.FileTransfer{
+		Source:      strings.Join(user.AuthorizedKeys, ", "),
+		Destination: path.Join(home, ".ssh", "authorized_keys"),
+		Folder:      path.Join(home, ".ssh"),
+		Chown:       user.Name,
+		Chmod:       "0600",
+		Contents:    contents,
+	}, nil
+}
+
+// Converts the group of a spec to what the accounts package compares
+func accountGroup(group parser.Group) accounts.Group {
+	return accounts.Group{Name: group.Name, GID: group.GID, System: group.System}
+}
+
+// Converts the user of a spec to what the accounts package compares
+func accountUser(user parser.User) accounts.User {
+	return accounts.User{
+		Name:   user.Name,
+		UID:    user.UID,
+		Group:  user.Group,
+		Groups: user.Groups,
+		Shell:  user.Shell,
+		Home:   user.Home,
+		System: user.System,
+	}
+}
+
+// Starts, stops, enables and disables services as the spec wants, skipping the ones already as wanted
+func (job *Job) applyServices(list []parser.Service) error {
+	for _, service := range list {
+		want := services.Want{State: service.State, Enabled: service.EnabledState()}
+		if want.State == "" && want.Enabled == "" {
+			continue
+		}
+
+		initSystem, err := job.initSystem()
+		if err != nil {
+			return job.fail("Unable to detect the init system! Aborting futher tasks for this server..", err)
+		}
+
+		status, err := initSystem.Status(job.Transport, service.Name)
+		if err != nil {
+			return job.fail("Unable to query service ["+service.Name+"]! Aborting futher tasks for this server..", err)
+		}
+
+		actions := services.Actions(status, want)
+		if len(actions) == 0 {
+			job.Summary.OK++
+			job.Summary.service(service.Name, "")
+			job.respond("=", "Service ["+service.Name+"] is already as wanted")
+			continue
+		}
+
+		for _, action := range actions {
+			if err := job.serviceAction(action, service.Name); err != nil {
+				return err
+			}
+			if action == "start" {
+				job.started = append(job.started, service.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Runs a single action on a service, eg: start or reload
+func (job *Job) serviceAction(action, service string) error {
+	initSystem, err := job.initSystem()
+	if err != nil {
+		return job.fail("Unable to detect the init system! Aborting futher tasks for this server..", err)
+	}
+
+	cmd := initSystem.Command(action, service)
+	job.respond("*", "Running Service Command: ["+cmd+"]...")
+	if _, err := job.Transport.Run(cmd); err != nil {
+		return job.fail("Service ["+service+"] failed to "+action+"! Aborting futher tasks for this server..", err)
+	}
+	job.Summary.Changed++
+	job.Summary.service(service, action)
+	job.respond("✓", "Service ["+service+"] "+action+" Succeeded!")
+
+	return nil
+}
+
+// Fetches the remote content of the spec, the first job to need it does the fetching. Cached
+// clones of branches and tags that couldn't be fetched are refused unless the job is Offline.
+func (job *Job) prepareContent() error {
+	cacheDir := job.CacheDir
+	if cacheDir == "" {
+		cacheDir = content.CacheDir()
+	}
+	if err := job.SpecList.PrepareContent(job.SpecName, cacheDir); err != nil {
+		return err
+	}
+
+	if err := job.SpecList.CheckFetched(job.SpecName); err != nil && !job.Offline {
+		return fmt.Errorf("%s. Configure with --offline to use it anyway", err)
+	}
+	return nil
+}
+
+// Returns the init system of the target, detecting it the first time it is needed
+func (job *Job) initSystem() (*services.InitSystem, error) {
+	if job.InitSystem != nil {
+		return job.InitSystem, nil
+	}
+
+	initSystem, err := services.Detect(job.Transport)
+	if err != nil {
+		return nil, err
+	}
+	job.InitSystem = initSystem
+	job.respond("✓", "Detected init system: "+initSystem.Name())
+
+	return initSystem, nil
+}
+
+// Records an action on a service, an empty action only makes sure the service is listed
+func (s *Summary) service(name, action string) {
+	for i := range s.Services {
+		if s.Services[i].Name == name {
+			if action != "" {
+				s.Services[i].Actions = append(s.Services[i].Actions, action)
+			}
+			return
+		}
+	}
+
+	result := ServiceResult{Name: name}
+	if action != "" {
+		result.Actions = []string{action}
+	}
+	s.Services = append(s.Services, result)
+}
+
+// Describes what was done to each service, eg: nginx (start, enable), php-fpm (ok)
+func (s Summary) ServiceList() string {
+	var list []string
+	for _, result := range s.Services {
+		actions := "ok"
+		if len(result.Actions) > 0 {
+			actions = strings.Join(result.Actions, ", ")
+		}
+		list = append(list, result.Name+" ("+actions+")")
+	}
+	return strings.Join(list, ", ")
+}
+
+// Checks whether a list holds a string
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the package manager of the target, detecting it the first time it is needed
+func (job *Job) packageManager() (*packages.Manager, error) {
+	if job.PackageManager != nil {
+		return job.PackageManager, nil
+	}
+
+	job.respond("*", "Detecting package manager...")
+	manager, err := packages.Detect(job.Transport)
+	if err != nil {
+		return nil, err
+	}
+	job.PackageManager = manager
+	job.respond("✓", "Detected package manager: "+manager.Name())
+
+	return manager, nil
+}
+
+func (job *Job) transferFiles(fileList *parser.FileTransfers) error {
+
+	for _, file := range *fileList {
+
+		if err := job.makeFolder(file); err != nil {
+			job.respond("X", "Unable to make directory: "+file.Folder)
+			return err
+		}
+
+		contents, err := job.render(file)
+		if err != nil {
+			return err
+		}
+
+		current, err := job.Transport.Stat(file.Destination)
+		if err != nil {
+			job.respond("X", "Unable to inspect file: "+file.Destination)
+			return err
+		}
+		if current.Exists && current.Sha256 == checksum(contents) {
+			if !permissionsDiffer(current, file.Chown, file.Chmod) {
+				job.Summary.OK++
+				job.respond("=", "File is unchanged: "+file.Destination)
+				continue
+			}
+
+			job.respond("*", "Setting ownership and mode of file: "+file.Destination)
+			if err := job.setPermissions(file.Destination, file.Chown, file.Chmod); err != nil {
+				job.respond("X", "Unable to set ownership or mode of file: "+file.Destination)
+				return err
+			}
+			job.Summary.Changed++
+			job.changed = append(job.changed, file.Destination)
+			job.respond("✓", "Completed ownership and mode of file: "+file.Destination)
+			continue
+		}
+		if current.Exists {
+			overwrite, err := job.reviewChange(file.Destination, contents)
+			if err != nil {
+				return err
+			}
+			if !overwrite {
+				job.Summary.Skipped++
+				job.respond("-", "Skipped overwriting file: "+file.Destination)
+				continue
+			}
+		}
+
+		job.respond("*", "Uploading file: "+file.Destination)
+
+		err = job.Transport.Put(transport.File{Destination: file.Destination, Contents: contents})
+		if err != nil {
+			job.respond("X", "Unable to write file: "+file.Destination)
+			return err
+		}
+
+		if err := job.setPermissions(file.Destination, file.Chown, file.Chmod); err != nil {
+			job.respond("X", "Unable to set ownership or mode of file: "+file.Destination)
+			return err
+		}
+
+		job.Summary.Changed++
+		job.changed = append(job.changed, file.Destination)
+		job.respond("✓", "Completed upload of file: "+file.Destination)
+	}
+
+	return nil
+}
+
+// Shows the diff between a file on the target and what would replace it, and when
+// ConfirmEach is set, asks whether to go ahead. Returns whether to overwrite the file.
+func (job *Job) reviewChange(destination string, contents []byte) (bool, error) {
+	current, err := job.Transport.Run("sudo cat " + transport.Quote(destination))
+	if err != nil {
+		job.respond("X", "Unable to read file: "+destination)
+		return false, err
+	}
+
+	interactive.Lock()
+	defer interactive.Unlock()
+
+	terminal.Notice(fmt.Sprintf("[%s] changes to %s:", job.Name, destination))
+	if bytes.IndexByte(contents, 0) >= 0 || strings.IndexByte(current, 0) >= 0 {
+		terminal.Information("Binary files differ")
+	} else {
+		terminal.PrintDiff(terminal.UnifiedDiff(destination, destination+" (new)", current, string(contents)))
+	}
+
+	if !job.ConfirmEach {
+		return true, nil
+	}
+
+	return terminal.PromptBool(fmt.Sprintf("Overwrite %s on [%s]?", destination, job.Name)), nil
+}
+
+// Creates the folder of a file if it is missing. Every folder that gets created is given the
+// ownership of the file, and its mode with the execute bits needed to enter it.
+func (job *Job) makeFolder(file parser.FileTransfer) error {
+
+	// Find the folders that don't exist yet
+	var missing []string
+	for dir := file.Folder; dir != "/" && dir != "."; dir = path.Dir(dir) {
+		info, err := job.Transport.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if info.Exists {
+			break
+		}
+		missing = append(missing, dir)
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if _, err := job.Transport.Run("sudo mkdir -p " + transport.Quote(file.Folder)); err != nil {
+		return err
+	}
+
+	for _, dir := range missing {
+		if err := job.setPermissions(dir, file.Chown, parser.DirMode(file.Chmod)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Changes the ownership and mode of a path on the target, empty values are left alone
+func (job *Job) setPermissions(target, chown, chmod string) error {
+	if chown != "" {
+		if _, err := job.Transport.Run("sudo chown " + transport.Quote(chown) + " " + transport.Quote(target)); err != nil {
+			return err
+		}
+	}
+
+	if chmod != "" {
+		if _, err := job.Transport.Run("sudo chmod " + transport.Quote(chmod) + " " + transport.Quote(target)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Checks whether the ownership or mode of a file on the target differ from what the spec wants.
+// Owners and groups can be given as names or ids, and anything Stat didn't report is taken to match.
+func permissionsDiffer(current transport.FileInfo, chown, chmod string) bool {
+	if chmod != "" && current.Mode != "" {
+		want, err := strconv.ParseUint(chmod, 8, 32)
+		have, haveErr := strconv.ParseUint(current.Mode, 8, 32)
+		if err != nil || haveErr != nil || want != have {
+			return true
+		}
+	}
+
+	if chown != "" {
+		owner, group := chown, ""
+		if colon := strings.Index(chown, ":"); colon >= 0 {
+			owner, group = chown[:colon], chown[colon+1:]
+		}
+		if owner != "" && current.Owner != "" && owner != current.Owner && owner != current.UID {
+			return true
+		}
+		if group != "" && current.Group != "" && group != current.Group && group != current.GID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reads a local file, interpolating it if the spec and the job both allow it
+func (job *Job) render(file parser.FileTransfer) ([]byte, error) {
+	if file.Contents != nil {
+		return file.Contents, nil
+	}
+
+	fileBytes, err := ioutil.ReadFile(file.Source)
+	if err != nil {
+		job.respond("X", "Unable to read local file: "+file.Source)
+		return nil, err
+	}
+
+	if !file.Interpolate || job.values == nil {
+		return fileBytes, nil
+	}
+
+	job.respond("*", "Interpolating on file: "+file.Destination)
+
+	result, err := job.interpolate(string(fileBytes))
+	if err != nil {
+		job.respond("X", "Unable to interpolate file: "+file.Source)
+		return nil, err
+	}
+
+	return []byte(result), nil
+}
+
+// Works out the value of every interpolation variable, returns nil when the job has no Vars
+func (job *Job) resolveVars() map[string]string {
+	if job.Vars == nil {
+		return nil
+	}
+	return parser.VarValues(ResolveVars(job.SpecList, job.SpecName, job.Vars, job.VarLayers...))
+}
+
+// Works out the value of each variable of a spec. The [VARS] of the spec and the specs it requires
+// are overridden by the built in variables that are set, and those by each of the given layers.
+func ResolveVars(specList *parser.SpecList, specName string, builtin map[string]string, layers ...parser.VarLayer) map[string]parser.Var {
+	// Built in variables that aren't set are only there so that they can always be referenced
+	unset := parser.VarLayer{Source: "built in", Values: make(map[string]string)}
+	set := parser.VarLayer{Source: "built in", Values: make(map[string]string)}
+	for name, value := range builtin {
+		if value == "" {
+			unset.Values[name] = value
+		} else {
+			set.Values[name] = value
+		}
+	}
+
+	all := append([]parser.VarLayer{unset}, specList.VarLayers(specName)...)
+	all = append(all, set)
+	all = append(all, layers...)
+
+	return parser.ResolveVars(all...)
+}
+
+// Replaces the ${var.name} references in a command, when the job has variables
+func (job *Job) interpolateCommand(command string) (string, error) {
+	if job.values == nil {
+		return command, nil
+	}
+	return parser.InterpolateCommand(command, job.values)
+}
+
+// Replaces the ${var.name} references in a template, when the job has variables
+func (job *Job) interpolate(text string) (string, error) {
+	if job.values == nil || !strings.Contains(text, "${") {
+		return text, nil
+	}
+
+	tree, err := hil.Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	varMap := make(map[string]ast.Variable)
+	for name, value := range job.values {
+		varMap["var."+name] = ast.Variable{
+			Type:  ast.TypeString,
+			Value: value,
+		}
+	}
+
+	result, err := hil.Eval(tree, &hil.EvalConfig{GlobalScope: &ast.BasicScope{VarMap: varMap}})
+	if err != nil {
+		return "", err
+	}
+
+	return result.Value.(string), nil
+}
+
+// Returns the hex sha256 checksum of some contents
+func checksum(contents []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(contents))
+}
+
+// Sends a status line for this job
+func (job *Job) respond(status, message string) {
+	job.Responses <- fmt.Sprintf(addSpaces("[%s] "+job.label(), 45)+" >> %s ", status, message)
+}
+
+// Sends a failure line along with its cause, and returns the cause
+func (job *Job) fail(message string, err error) error {
+	job.Summary.Failed++
+	job.Errors <- fmt.Errorf(addSpaces("[%s] "+job.label(), 45)+" >> %s ", "X", message)
+	job.Errors <- fmt.Errorf("Error: %s", err)
+	return err
+}
+
+// Labels the output with the target, and the spec being applied to it
+func (job *Job) label() string {
+	if job.spec == "" {
+		return "[" + job.Name + "]"
+	}
+	return "[" + job.Name + "] [" + job.spec + "]"
+}
+
+func addSpaces(s string, w int) string {
+	if len(s) < w {
+		s += strings.Repeat(" ", w-len(s))
+	}
+	return s
+}
@@ -0,0 +1,550 @@
+package engine_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/praveensastry/cm/internal/engine"
+	"github.com/praveensastry/cm/internal/packages"
+	"github.com/praveensastry/cm/internal/parser"
+	"github.com/praveensastry/cm/internal/services"
+	"github.com/praveensastry/cm/internal/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+func newJob(t *testing.T, specName string) (*engine.Job, *transport.Fake) {
+	specList, err := parser.LoadSpecs("../../specs/")
+	assert.NoError(t, err)
+
+	fake := transport.NewFake()
+	fake.Responder = func(cmd string) (string, error) {
+		// The php repository isn't added yet
+		if strings.HasPrefix(cmd, "grep") {
+			return "", errors.New("exit status 1")
+		}
+		return "", nil
+	}
+	job := &engine.Job{
+		Name:      "fake",
+		Transport: fake,
+		SpecList:  specList,
+		SpecName:  specName,
+		Responses: make(chan string, 1000),
+		Errors:    make(chan error, 1000),
+
+		PackageManager: packages.Apt,
+		InitSystem:     services.Systemd,
+	}
+
+	return job, fake
+}
+
+func TestJobRun(t *testing.T) {
+	job, fake := newJob(t, "hello_world")
+
+	assert.NoError(t, job.Run())
+
+	assert.Contains(t, fake.Files, "/etc/nginx/sites-available/default")
+	assert.Contains(t, fake.Files, "/var/www/html/hello_world/index.php")
+	assert.Contains(t, fake.Commands, "sudo add-apt-repository -y ppa:ondrej/php")
+	assert.Contains(t, fake.Commands, "sudo service nginx reload")
+}
+
+func TestJobRunAbortsOnFailure(t *testing.T) {
+	job, fake := newJob(t, "hello_world")
+	fake.Responder = func(cmd string) (string, error) {
+		if strings.Contains(cmd, "apt-get install") {
+			return "", errors.New("exit status 100")
+		}
+		return "", nil
+	}
+
+	assert.Error(t, job.Run())
+
+	assert.Empty(t, fake.Files)
+	assert.NotContains(t, fake.Commands, "sudo service nginx reload")
+}
+
+func TestJobRunInterpolates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-engine")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	spec := "NAME = site\n\n[CONFIGS]\n\tdebian_root = \"/etc/\"\n"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "site.spec"), []byte(spec), 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "configs", "site"), 0755))
+	template := "server_name ${var.class}-${var.sequence}.${var.locale}; # ${var.specname}\n"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "configs", "site", "site.conf"), []byte(template), 0644))
+
+	specList, err := parser.LoadSpecs(dir)
+	assert.NoError(t, err)
+
+	fake := transport.NewFake()
+	job := &engine.Job{
+		Name:      "fake",
+		Transport: fake,
+		SpecList:  specList,
+		SpecName:  "site",
+		Vars:      engine.NewVars("site", "web", "01", "us-east"),
+		Responses: make(chan string, 1000),
+		Errors:    make(chan error, 1000),
+	}
+
+	assert.NoError(t, job.Run())
+	assert.Equal(t, "server_name web-01.us-east; # site\n", string(fake.Files["/etc/site/site.conf"]))
+}
+
+func TestJobRunVars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-engine")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	spec := "NAME = site\n\n[CONFIGS]\n\tdebian_root = \"/etc/\"\n\n[COMMANDS]\n\tpost = mkdir -p ${var.root}, echo $${var.root}, echo ${HOME} ${1}\n\n[VARS]\n\tport = 80\n\troot = /srv/site\n\tclass = default\n"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "site.spec"), []byte(spec), 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "configs", "site"), 0755))
+	template := "listen ${var.port}; root ${var.root}; # ${var.class}\n"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "configs", "site", "site.conf"), []byte(template), 0644))
+
+	specList, err := parser.LoadSpecs(dir)
+	assert.NoError(t, err)
+
+	fake := transport.NewFake()
+	job := &engine.Job{
+		Name:      "fake",
+		Transport: fake,
+		SpecList:  specList,
+		SpecName:  "site",
+		Vars:      engine.NewVars("site", "", "01", "us-east"),
+		VarLayers: []parser.VarLayer{{Source: "host web01", Values: map[string]string{"port": "8080"}}},
+		Responses: make(chan string, 1000),
+		Errors:    make(chan error, 1000),
+	}
+
+	assert.NoError(t, job.Run())
+	assert.Equal(t, "listen 8080; root /srv/site; # default\n", string(fake.Files["/etc/site/site.conf"]))
+	assert.Contains(t, fake.Commands, "mkdir -p /srv/site")
+	assert.Contains(t, fake.Commands, "echo ${var.root}")
+	assert.Contains(t, fake.Commands, "echo ${HOME} ${1}", "shell expansions are left alone")
+}
+
+func TestJobPlan(t *testing.T) {
+	job, fake := newJob(t, "hello_world")
+
+	index, err := ioutil.ReadFile("../../specs/hello_world/content/hello_world/index.php")
+	assert.NoError(t, err)
+	fake.Files["/var/www/html/hello_world/index.php"] = index
+	fake.Files["/etc/nginx/sites-available/default"] = []byte("# edited by hand\n")
+	fake.Responder = func(cmd string) (string, error) {
+		if strings.HasPrefix(cmd, "dpkg-query") {
+			return "nginx 1.18.0-0ubuntu1 install ok installed\nphp5-fpm 5.6.40 deinstall ok config-files\n", nil
+		}
+		return "", nil
+	}
+
+	plan, err := job.Plan()
+	assert.NoError(t, err)
+
+	actions := make(map[string]string)
+	for _, file := range plan.Files {
+		actions[file.Destination] = file.Action
+	}
+	assert.Equal(t, "unchanged", actions["/var/www/html/hello_world/index.php"])
+	assert.Equal(t, "update", actions["/etc/nginx/sites-available/default"])
+	assert.NotContains(t, plan.Packages, "nginx")
+	assert.Contains(t, plan.Packages, "php5-fpm")
+	assert.Contains(t, plan.Commands, "sudo service nginx reload")
+
+	// Nothing but queries ran
+	for _, cmd := range fake.Commands {
+		assert.True(t, strings.HasPrefix(cmd, "dpkg-query"), cmd)
+	}
+	assert.Equal(t, "# edited by hand\n", string(fake.Files["/etc/nginx/sites-available/default"]))
+}
+
+func TestJobRunOverwritesChangedFiles(t *testing.T) {
+	job, fake := newJob(t, "hello_world")
+	fake.Files["/etc/nginx/sites-available/default"] = []byte("# edited by hand\n")
+	fake.Responder = func(cmd string) (string, error) {
+		if cmd == "sudo cat '/etc/nginx/sites-available/default'" {
+			return "# edited by hand\n", nil
+		}
+		return "", nil
+	}
+
+	assert.NoError(t, job.Run())
+
+	assert.Contains(t, fake.Commands, "sudo cat '/etc/nginx/sites-available/default'")
+	assert.Contains(t, string(fake.Files["/etc/nginx/sites-available/default"]), "installed from spec file")
+}
+
+func TestJobRunSkipsUnchangedFiles(t *testing.T) {
+	job, fake := newJob(t, "hello_world")
+	assert.NoError(t, job.Run())
+	files := len(fake.Files)
+	assert.Equal(t, 0, job.Summary.OK)
+
+	job.Summary = engine.Summary{}
+	fake.Commands = nil
+	assert.NoError(t, job.Run())
+
+	assert.Equal(t, files, job.Summary.OK)
+	assert.NotZero(t, job.Summary.Ran, "commands without guards still run")
+	assert.Equal(t, 0, job.Summary.Failed)
+	for _, cmd := range fake.Commands {
+		assert.NotContains(t, cmd, "sudo cat", "unchanged files shouldn't be diffed")
+	}
+	assert.Contains(t, fake.Commands, "sudo service nginx start")
+	assert.NotContains(t, fake.Commands, "sudo service nginx reload", "handlers only run when their files change")
+}
+
+func TestJobRunFixesPermissionsOfUnchangedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-engine")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	spec := "NAME = site\n\n[CONTENT]\n\tsource = spec\n\tdebian_root = \"/var/www/\"\n\towner = www-data\n\tgroup = www-data\n\tmode = 0640\n"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "site.spec"), []byte(spec), 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "content", "site"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "content", "site", "index.php"), []byte("<?php\n"), 0644))
+
+	specList, err := parser.LoadSpecs(dir)
+	assert.NoError(t, err)
+
+	fake := transport.NewFake()
+	fake.Files["/var/www/site/index.php"] = []byte("<?php\n")
+	fake.Modes = map[string]string{"/var/www/site/index.php": "644"}
+	fake.Owners = map[string]string{"/var/www/site/index.php": "www-data:www-data"}
+	job := &engine.Job{
+		Name:      "fake",
+		Transport: fake,
+		SpecList:  specList,
+		SpecName:  "site",
+		Responses: make(chan string, 1000),
+		Errors:    make(chan error, 1000),
+	}
+
+	assert.NoError(t, job.Run())
+	assert.Equal(t, 1, job.Summary.Changed)
+	assert.Equal(t, 0, job.Summary.OK)
+	assert.Contains(t, fake.Commands, "sudo chmod '0640' '/var/www/site/index.php'")
+
+	// Once the mode matches, the file is left alone
+	fake.Modes["/var/www/site/index.php"] = "640"
+	fake.Commands, job.Summary = nil, engine.Summary{}
+	assert.NoError(t, job.Run())
+	assert.Equal(t, 0, job.Summary.Changed)
+	assert.Equal(t, 1, job.Summary.OK)
+	assert.NotContains(t, fake.Commands, "sudo chmod '0640' '/var/www/site/index.php'")
+}
+
+func TestJobRunAppliesEachSpecInTurn(t *testing.T) {
+	index := func(commands []string, cmd string) int {
+		for i, c := range commands {
+			if c == cmd {
+				return i
+			}
+		}
+		t.Fatalf("command was not run: %s", cmd)
+		return -1
+	}
+
+	// nginx is started before php is looked at
+	job, fake := newJob(t, "hello_world")
+	assert.NoError(t, job.Run())
+	assert.Less(t, index(fake.Commands, "sudo service nginx start"), index(fake.Commands, "sudo add-apt-repository -y ppa:ondrej/php"))
+
+	// The flattened run does every pre-configure command first
+	job, fake = newJob(t, "hello_world")
+	job.Flat = true
+	assert.NoError(t, job.Run())
+	assert.Greater(t, index(fake.Commands, "sudo service nginx start"), index(fake.Commands, "sudo add-apt-repository -y ppa:ondrej/php"))
+}
+
+func TestJobRunSkipsInstalledPackages(t *testing.T) {
+	job, fake := newJob(t, "nginx")
+	fake.Responder = func(cmd string) (string, error) {
+		if strings.HasPrefix(cmd, "dpkg-query") {
+			return "nginx 1.18.0-0ubuntu1 install ok installed\n", nil
+		}
+		return "", nil
+	}
+
+	assert.NoError(t, job.Run())
+
+	for _, cmd := range fake.Commands {
+		assert.NotContains(t, cmd, "apt-get install", "installed packages shouldn't be installed again")
+	}
+	assert.Equal(t, 1, job.Summary.OK)
+}
+
+func TestJobRunServices(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-engine")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	spec := "NAME = web\n\n[CONFIGS]\n\tdebian_root = \"/etc/\"\n\n[SERVICES.nginx]\n\tstate = running\n\tenabled = true\n\ton_change = reload\n\twatch = /etc/nginx/**\n"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "web.spec"), []byte(spec), 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "configs", "nginx"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "configs", "nginx", "nginx.conf"), []byte("worker_processes 1;\n"), 0644))
+
+	specList, err := parser.LoadSpecs(dir)
+	assert.NoError(t, err)
+
+	run := func(fake *transport.Fake, active, enabled string) *engine.Job {
+		fake.Responder = func(cmd string) (string, error) {
+			switch {
+			case strings.HasPrefix(cmd, "systemctl is-active"):
+				return active + "\n", nil
+			case strings.HasPrefix(cmd, "systemctl is-enabled"):
+				return enabled + "\n", nil
+			}
+			return "", nil
+		}
+		job := &engine.Job{
+			Name:       "fake",
+			Transport:  fake,
+			SpecList:   specList,
+			SpecName:   "web",
+			Responses:  make(chan string, 1000),
+			Errors:     make(chan error, 1000),
+			InitSystem: services.Systemd,
+		}
+		assert.NoError(t, job.Run())
+		return job
+	}
+
+	// A stopped service is started and enabled, which also picks up the new config
+	fake := transport.NewFake()
+	job := run(fake, "inactive", "disabled")
+	assert.Contains(t, fake.Commands, "sudo systemctl enable 'nginx'")
+	assert.Contains(t, fake.Commands, "sudo systemctl start 'nginx'")
+	assert.NotContains(t, fake.Commands, "sudo systemctl reload 'nginx'")
+	assert.Equal(t, "nginx (enable, start)", job.Summary.ServiceList())
+
+	// A running service is reloaded when its config changes
+	fake = transport.NewFake()
+	job = run(fake, "active", "enabled")
+	assert.Contains(t, fake.Commands, "sudo systemctl reload 'nginx'")
+	assert.Equal(t, "nginx (reload)", job.Summary.ServiceList())
+
+	// Nothing to do the second time round
+	fake.Commands = nil
+	job = run(fake, "active", "enabled")
+	for _, cmd := range fake.Commands {
+		assert.NotContains(t, cmd, "sudo systemctl", "services already as wanted shouldn't be touched")
+	}
+	assert.Equal(t, "nginx (ok)", job.Summary.ServiceList())
+}
+
+func TestJobRunUsers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-engine")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	spec := "NAME = deploy\n\n[GROUPS.deploy]\n\tgid = 1500\n\n[USERS.deploy]\n\tuid = 1500\n\tshell = /bin/bash\n\tgroups = www-data\n\tauthorized_keys = keys/deploy.pub\n"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "deploy.spec"), []byte(spec), 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "keys"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "keys", "deploy.pub"), []byte("ssh-ed25519 AAAA deploy@laptop"), 0644))
+
+	specList, err := parser.LoadSpecs(dir)
+	assert.NoError(t, err)
+
+	run := func(fake *transport.Fake) *engine.Job {
+		job := &engine.Job{
+			Name:      "fake",
+			Transport: fake,
+			SpecList:  specList,
+			SpecName:  "deploy",
+			Responses: make(chan string, 1000),
+			Errors:    make(chan error, 1000),
+		}
+		assert.NoError(t, job.Run())
+		return job
+	}
+
+	// A new group and user are created, the user joining the group named after it
+	fake := transport.NewFake()
+	created := false
+	fake.Responder = func(cmd string) (string, error) {
+		if strings.HasPrefix(cmd, "sudo groupadd") {
+			created = true
+		}
+		if cmd == "getent group 'deploy' || true" && created {
+			return "deploy:x:1500:\n", nil
+		}
+		return "", nil
+	}
+	run(fake)
+	assert.Contains(t, fake.Commands, "sudo groupadd -g '1500' 'deploy'")
+	assert.Contains(t, fake.Commands, "sudo useradd -m -u '1500' -g 'deploy' -G 'www-data' -s '/bin/bash' 'deploy'")
+	assert.Equal(t, "ssh-ed25519 AAAA deploy@laptop\n", string(fake.Files["/home/deploy/.ssh/authorized_keys"]))
+	assert.Contains(t, fake.Commands, "sudo chown 'deploy' '/home/deploy/.ssh'")
+	assert.Contains(t, fake.Commands, "sudo chmod '0700' '/home/deploy/.ssh'")
+	assert.Contains(t, fake.Commands, "sudo chmod '0600' '/home/deploy/.ssh/authorized_keys'")
+
+	// An existing user missing a group is only added to it
+	fake = transport.NewFake()
+	fake.Responder = func(cmd string) (string, error) {
+		switch {
+		case strings.HasPrefix(cmd, "getent group"):
+			return "deploy:x:1500:\n", nil
+		case strings.HasPrefix(cmd, "getent passwd"):
+			return "deploy:x:1500:1500::/srv/deploy:/bin/bash\n", nil
+		case strings.HasPrefix(cmd, "id -nG"):
+			return "deploy\n", nil
+		}
+		return "", nil
+	}
+	job := run(fake)
+	assert.Contains(t, fake.Commands, "sudo usermod -a -G 'www-data' 'deploy'")
+	assert.Contains(t, fake.Files, "/srv/deploy/.ssh/authorized_keys", "keys go to the home the user has")
+	for _, cmd := range fake.Commands {
+		assert.NotContains(t, cmd, "groupadd")
+		assert.NotContains(t, cmd, "useradd")
+	}
+	assert.Equal(t, 1, job.Summary.OK)
+}
+
+func TestJobRunCommandGuards(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-engine")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	spec := "NAME = guarded\n\n[COMMANDS]\n" +
+		"\tpre = \"creates=/etc/ssl/dhparam.pem timeout=5m openssl dhparam -out /etc/ssl/dhparam.pem 2048, unless='grep -q done /tmp/state' echo done > /tmp/state\"\n" +
+		"\tpost = \"onlyif='test -d /srv/app' dir=/srv/app ./migrate\"\n"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "guarded.spec"), []byte(spec), 0644))
+
+	specList, err := parser.LoadSpecs(dir)
+	assert.NoError(t, err)
+
+	run := func(fake *transport.Fake) *engine.Job {
+		job := &engine.Job{
+			Name:      "fake",
+			Transport: fake,
+			SpecList:  specList,
+			SpecName:  "guarded",
+			Responses: make(chan string, 1000),
+			Errors:    make(chan error, 1000),
+		}
+		assert.NoError(t, job.Run())
+		return job
+	}
+
+	// Every guard says the command is needed
+	fake := transport.NewFake()
+	fake.Responder = func(cmd string) (string, error) {
+		if strings.Contains(cmd, "grep -q done") {
+			return "", errors.New("exit status 1")
+		}
+		return "", nil
+	}
+	job := run(fake)
+	assert.Contains(t, fake.Commands, "timeout 300 sh -c 'openssl dhparam -out /etc/ssl/dhparam.pem 2048'")
+	assert.Contains(t, fake.Commands, "echo done > /tmp/state")
+	assert.Contains(t, fake.Commands, "cd '/srv/app' && test -d /srv/app")
+	assert.Contains(t, fake.Commands, "cd '/srv/app' && ./migrate")
+	assert.Equal(t, 3, job.Summary.Changed)
+
+	// Every guard says the command can be skipped
+	fake = transport.NewFake()
+	fake.Files["/etc/ssl/dhparam.pem"] = []byte("dhparam")
+	fake.Responder = func(cmd string) (string, error) {
+		if strings.Contains(cmd, "test -d /srv/app") {
+			return "", errors.New("exit status 1")
+		}
+		return "", nil
+	}
+	job = run(fake)
+	for _, cmd := range fake.Commands {
+		assert.NotContains(t, cmd, "openssl")
+		assert.NotContains(t, cmd, "echo done")
+		assert.NotContains(t, cmd, "./migrate")
+	}
+	assert.Equal(t, 0, job.Summary.Changed)
+	assert.Equal(t, 3, job.Summary.OK)
+}
+
+func TestJobRunGitContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-engine")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// A repository with the site in a folder of its own
+	repo := filepath.Join(dir, "site")
+	assert.NoError(t, os.MkdirAll(filepath.Join(repo, "public"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(repo, "public", "index.html"), []byte("<h1>hello</h1>\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(repo, "README.md"), []byte("not deployed\n"), 0644))
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=cm", "GIT_AUTHOR_EMAIL=cm@example.com", "GIT_COMMITTER_NAME=cm", "GIT_COMMITTER_EMAIL=cm@example.com")
+		output, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(output))
+		return strings.TrimSpace(string(output))
+	}
+	git("init", "-q", "-b", "main")
+	git("add", "-A")
+	git("commit", "-q", "-m", "site")
+	commit := git("rev-parse", "HEAD")
+
+	specs := filepath.Join(dir, "specs")
+	assert.NoError(t, os.MkdirAll(specs, 0755))
+	spec := "NAME = site\n\n[CONTENT]\n\tsource = git\n\trepo = file://" + repo + "\n\tref = main\n\tsubdir = public\n\tdebian_root = \"/var/www/html/\"\n"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(specs, "site.spec"), []byte(spec), 0644))
+
+	specList, err := parser.LoadSpecs(specs)
+	assert.NoError(t, err)
+
+	fake := transport.NewFake()
+	job := &engine.Job{
+		Name:      "fake",
+		Transport: fake,
+		SpecList:  specList,
+		SpecName:  "site",
+		Responses: make(chan string, 1000),
+		Errors:    make(chan error, 1000),
+		CacheDir:  filepath.Join(dir, "cache"),
+	}
+	assert.NoError(t, job.Run())
+
+	assert.Equal(t, "<h1>hello</h1>\n", string(fake.Files["/var/www/html/index.html"]))
+	assert.Len(t, fake.Files, 1, "only the subdir is transferred")
+
+	pinned := false
+	close(job.Responses)
+	for response := range job.Responses {
+		if strings.Contains(response, commit) {
+			pinned = true
+		}
+	}
+	assert.True(t, pinned, "the output names the commit")
+
+	// When main can't be fetched, the cached clone may be behind, so it is only used offline
+	assert.NoError(t, os.Rename(repo, repo+"-moved"))
+	for _, offline := range []bool{false, true} {
+		specList, err := parser.LoadSpecs(specs)
+		assert.NoError(t, err)
+		job := &engine.Job{
+			Name:      "fake",
+			Transport: transport.NewFake(),
+			SpecList:  specList,
+			SpecName:  "site",
+			Responses: make(chan string, 1000),
+			Errors:    make(chan error, 1000),
+			CacheDir:  filepath.Join(dir, "cache"),
+			Offline:   offline,
+		}
+		err = job.Run()
+		if offline {
+			assert.NoError(t, err)
+		} else if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "--offline")
+		}
+	}
+}
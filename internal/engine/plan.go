@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"github.com/praveensastry/cm/internal/accounts"
+	"github.com/praveensastry/cm/internal/packages"
+	"github.com/praveensastry/cm/internal/parser"
+	"github.com/praveensastry/cm/internal/services"
+	"github.com/praveensastry/cm/terminal"
+)
+
+// What a job would do to its target, without doing any of it
+type Plan struct {
+	Name     string
+	Files    []FileChange
+	Packages []string // packages that are not installed yet
+	Upgrade  []string // packages installed at a version their pin doesn't allow
+	Remove   []string // absent packages that are installed
+	Hold     []string // packages that are not held yet
+	Commands []string // every command that would run, in order
+}
+
+// How a single file would change
+type FileChange struct {
+	Destination string
+	Action      string // create, update or unchanged, updates include ownership and mode changes
+}
+
+// Compares the spec against the target and returns what Run would change. Nothing on the target is modified.
+func (job *Job) Plan() (*Plan, error) {
+	plan := &Plan{Name: job.Name}
+	job.values = job.resolveVars()
+
+	if _, err := job.SpecList.Resolve(job.SpecName); err != nil {
+		return plan, job.fail("Unable to resolve the requirements of spec ["+job.SpecName+"]!", err)
+	}
+	if err := job.prepareContent(); err != nil {
+		return plan, job.fail("Unable to fetch content!", err)
+	}
+
+	// Compare the rendered files, and the authorized keys of the users, with what is already there
+	files := *job.SpecList.DebianFileTransferList(job.SpecName)
+	flat := job.SpecList.FlatLifecycle(job.SpecName)
+	for _, user := range flat.Users {
+		if len(user.AuthorizedKeys) == 0 {
+			continue
+		}
+		file, err := job.authorizedKeys(user)
+		if err != nil {
+			return plan, job.fail("Unable to read the authorized keys of user ["+user.Name+"]!", err)
+		}
+		files = append(files, file)
+	}
+	for _, file := range files {
+		contents, err := job.render(file)
+		if err != nil {
+			return plan, job.fail("Unable to render file: "+file.Source, err)
+		}
+
+		current, err := job.Transport.Stat(file.Destination)
+		if err != nil {
+			return plan, job.fail("Unable to inspect file: "+file.Destination, err)
+		}
+
+		change := FileChange{Destination: file.Destination, Action: "unchanged"}
+		if !current.Exists {
+			change.Action = "create"
+		} else if current.Sha256 != checksum(contents) || permissionsDiffer(current, file.Chown, file.Chmod) {
+			change.Action = "update"
+		}
+		plan.Files = append(plan.Files, change)
+	}
+
+	// Find the packages that are missing, at the wrong version, unwanted or not held yet
+	var manager *packages.Manager
+	if flat.HasPackages() {
+		var err error
+		if manager, err = job.packageManager(); err != nil {
+			return plan, job.fail("Unable to detect the package manager!", err)
+		}
+
+		changes, err := manager.Compare(job.Transport, packages.State{Install: flat.Packages[manager.Name()], Absent: flat.Absent, Held: flat.Held})
+		if err != nil {
+			return plan, job.fail("Unable to query packages!", err)
+		}
+		for _, pkg := range changes.Install {
+			plan.Packages = append(plan.Packages, pkg.String())
+		}
+		for _, pkg := range changes.Upgrade {
+			plan.Upgrade = append(plan.Upgrade, pkg.String())
+		}
+		plan.Remove = changes.Remove
+		plan.Hold = changes.Hold
+	}
+
+	// The commands run in the same order Run would run them
+	lifecycles := job.SpecList.Lifecycles(job.SpecName)
+	if job.Flat {
+		lifecycles = []parser.Lifecycle{flat}
+	}
+	var started []string
+	for _, lifecycle := range lifecycles {
+		plan.Commands = append(plan.Commands, job.describeCommands(lifecycle.PreCmds)...)
+		if manager != nil && lifecycle.HasPackages() {
+			changes, err := manager.Compare(job.Transport, packages.State{Install: lifecycle.Packages[manager.Name()], Absent: lifecycle.Absent, Held: lifecycle.Held})
+			if err != nil {
+				return plan, job.fail("Unable to query packages!", err)
+			}
+			plan.Commands = append(plan.Commands, manager.Commands(changes)...)
+		}
+		for _, group := range lifecycle.Groups {
+			cmds, err := accounts.GroupCommands(job.Transport, accountGroup(group))
+			if err != nil {
+				return plan, job.fail("Unable to query group ["+group.Name+"]!", err)
+			}
+			plan.Commands = append(plan.Commands, cmds...)
+		}
+		for _, user := range lifecycle.Users {
+			cmds, err := accounts.UserCommands(job.Transport, accountUser(user))
+			if err != nil {
+				return plan, job.fail("Unable to query user ["+user.Name+"]!", err)
+			}
+			plan.Commands = append(plan.Commands, cmds...)
+		}
+		for _, service := range lifecycle.Services {
+			want := services.Want{State: service.State, Enabled: service.EnabledState()}
+			if want.State == "" && want.Enabled == "" {
+				continue
+			}
+			initSystem, err := job.initSystem()
+			if err != nil {
+				return plan, job.fail("Unable to detect the init system!", err)
+			}
+			status, err := initSystem.Status(job.Transport, service.Name)
+			if err != nil {
+				return plan, job.fail("Unable to query service ["+service.Name+"]!", err)
+			}
+			for _, action := range services.Actions(status, want) {
+				plan.Commands = append(plan.Commands, initSystem.Command(action, service.Name))
+				if action == "start" {
+					started = append(started, service.Name)
+				}
+			}
+		}
+		plan.Commands = append(plan.Commands, job.describeCommands(lifecycle.PostCmds)...)
+	}
+
+	// Handlers and services only react to the files that would change
+	var changing []string
+	for _, file := range plan.Files {
+		if file.Action != "unchanged" {
+			changing = append(changing, file.Destination)
+		}
+	}
+	for _, handler := range job.SpecList.Handlers(job.SpecName) {
+		if handler.Notified(changing) {
+			plan.Commands = append(plan.Commands, job.describeCommands(handler.Commands)...)
+		}
+	}
+	for _, service := range job.SpecList.Services(job.SpecName) {
+		if !service.Notified(changing) || service.State == "stopped" || contains(started, service.Name) {
+			continue
+		}
+		initSystem, err := job.initSystem()
+		if err != nil {
+			return plan, job.fail("Unable to detect the init system!", err)
+		}
+		plan.Commands = append(plan.Commands, initSystem.Command(service.ChangeAction(), service.Name))
+	}
+
+	return plan, nil
+}
+
+// Describes pre or post-configure commands with their guards, which the plan leaves unchecked
+// as they are commands too
+func (job *Job) describeCommands(entries []string) []string {
+	var described []string
+	for _, entry := range entries {
+		if interpolated, err := job.interpolateCommand(entry); err == nil {
+			entry = interpolated
+		}
+		if command, err := parser.ParseCommand(entry); err == nil {
+			described = append(described, command.String())
+		} else {
+			described = append(described, entry)
+		}
+	}
+	return described
+}
+
+// Counts the files with a given action
+func (p *Plan) Count(action string) int {
+	count := 0
+	for _, file := range p.Files {
+		if file.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// Prints the plan for a target
+func (p *Plan) Print() {
+	terminal.PrintAnsi(PlanTemplate, p)
+}
+
+var PlanTemplate = `
+{{ansi ""}}{{ ansi "underscore"}}{{ ansi "bright" }}{{ ansi "fgwhite"}}[{{ .Name }}]{{ ansi ""}} {{ .Count "create" }} to create, {{ .Count "update" }} to update, {{ .Count "unchanged" }} unchanged
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}                   Files: {{ ansi ""}}{{ range .Files }}{{ if eq .Action "create" }}{{ ansi "fggreen"}}+ {{ else if eq .Action "update" }}{{ ansi "fgyellow"}}~ {{ else }}{{ ansi "fgcyan"}}= {{ end }}{{ .Destination }} ({{ .Action }}){{ ansi ""}}
+				  {{ end }}
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}     Packages to install: {{ ansi ""}}{{ ansi "fggreen"}}{{ range .Packages }}{{ . }} {{ end }}{{ ansi ""}}
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}     Packages to upgrade: {{ ansi ""}}{{ ansi "fgyellow"}}{{ range .Upgrade }}{{ . }} {{ end }}{{ ansi ""}}
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}      Packages to remove: {{ ansi ""}}{{ ansi "fgred"}}{{ range .Remove }}{{ . }} {{ end }}{{ ansi ""}}
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}        Packages to hold: {{ ansi ""}}{{ ansi "fgcyan"}}{{ range .Hold }}{{ . }} {{ end }}{{ ansi ""}}
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}  Commands that will run: {{ ansi ""}}{{ ansi "fgcyan"}}{{ range .Commands }}{{ . }}
+				  {{ end }}{{ ansi ""}}
+`
@@ -0,0 +1,324 @@
+package packages
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/praveensastry/cm/internal/transport"
+)
+
+// A package manager on the target, and the commands it takes to update, install, remove and query packages
+type Manager struct {
+	name    string
+	update  string // refreshes the package index
+	install string // installs the packages that follow it
+	remove  string // removes the packages that follow it
+	hold    string // keeps the packages that follow it at their version, empty when not supported
+	held    string // lists the held packages, one per line
+	query   string // lists which of the packages that follow it are installed, see parse
+	parse   func(fields []string, names []string) (name, version string, installed bool)
+	pin     string // goes between a package name and its version when installing, empty when not supported
+}
+
+var (
+	Apt = &Manager{
+		name:    "apt",
+		update:  "sudo apt-get update -o Dpkg::Options::=\"--force-confdef\" -o Dpkg::Options::=\"--force-confold\"",
+		install: "sudo apt-get install -y -f --assume-yes --allow-unauthenticated",
+		remove:  "sudo apt-get purge -y",
+		hold:    "sudo apt-mark hold",
+		held:    "apt-mark showhold",
+		// dpkg-query fails when any of the packages is unknown, but still lists the ones it knows
+		query: "dpkg-query -W -f='${Package} ${Version} ${Status}\\n'",
+		parse: func(fields []string, names []string) (string, string, bool) {
+			if len(fields) != 5 || fields[4] != "installed" {
+				return "", "", false
+			}
+			return fields[0], fields[1], true
+		},
+		pin: "=",
+	}
+	Dnf = &Manager{
+		name:    "dnf",
+		update:  "sudo dnf makecache -y",
+		install: "sudo dnf install -y",
+		remove:  "sudo dnf remove -y",
+		query:   rpmQuery,
+		parse:   parseRPM,
+		pin:     "-",
+	}
+	Yum = &Manager{
+		name:    "yum",
+		update:  "sudo yum makecache -y",
+		install: "sudo yum install -y",
+		remove:  "sudo yum remove -y",
+		query:   rpmQuery,
+		parse:   parseRPM,
+		pin:     "-",
+	}
+	Zypper = &Manager{
+		name:    "zypper",
+		update:  "sudo zypper --non-interactive refresh",
+		install: "sudo zypper --non-interactive install",
+		remove:  "sudo zypper --non-interactive remove",
+		query:   rpmQuery,
+		parse:   parseRPM,
+		pin:     "=",
+	}
+	Apk = &Manager{
+		name:    "apk",
+		update:  "sudo apk update",
+		install: "sudo apk add",
+		remove:  "sudo apk del",
+		// Prints a line like "nginx-1.18.0-r1 x86_64 {nginx} (BSD-2-Clause) [installed]" for each installed package
+		query: "apk list -I",
+		parse: func(fields []string, names []string) (string, string, bool) {
+			if fields[len(fields)-1] != "[installed]" {
+				return "", "", false
+			}
+			// The name and version are joined with a dash, and names can have dashes too
+			name := ""
+			for _, n := range names {
+				rest := strings.TrimPrefix(fields[0], n+"-")
+				if rest != fields[0] && len(rest) > 0 && rest[0] >= '0' && rest[0] <= '9' && len(n) > len(name) {
+					name = n
+				}
+			}
+			return name, strings.TrimPrefix(fields[0], name+"-"), name != ""
+		},
+		pin: "=",
+	}
+	Pacman = &Manager{
+		name:    "pacman",
+		update:  "sudo pacman -Sy --noconfirm",
+		install: "sudo pacman -S --noconfirm --needed",
+		remove:  "sudo pacman -Rns --noconfirm",
+		// Prints the name and version of each package that is installed
+		query: "pacman -Q",
+		parse: func(fields []string, names []string) (string, string, bool) {
+			if len(fields) != 2 {
+				return "", "", false
+			}
+			return fields[0], fields[1], true
+		},
+		// pacman only installs the version its repositories have, and takes name=version as a
+		// dependency to satisfy rather than a version to install
+		pin: "",
+	}
+)
+
+// Prints "<name> <version> installed" for each package that is, and a sentence for each that isn't
+const rpmQuery = "rpm -q --qf '%{NAME} %{VERSION}-%{RELEASE} installed\\n'"
+
+func parseRPM(fields []string, names []string) (string, string, bool) {
+	if len(fields) != 3 || fields[2] != "installed" {
+		return "", "", false
+	}
+	return fields[0], fields[1], true
+}
+
+// Every supported package manager
+var Managers = []*Manager{Apt, Dnf, Yum, Zypper, Apk, Pacman}
+
+// The name of the package manager, as used for its list in a spec's [PACKAGES] section
+func (m *Manager) Name() string {
+	return m.name
+}
+
+// Checks that the package manager can install the versions a list of packages is pinned to
+func (m *Manager) CheckPins(entries []string) error {
+	if m.pin != "" {
+		return nil
+	}
+	for _, entry := range entries {
+		if ParsePackage(entry).Version != "" {
+			return fmt.Errorf("pinning package versions isn't supported with %s, unable to install [%s]", m.name, entry)
+		}
+	}
+	return nil
+}
+
+// Asks the target which of the given packages are installed, and returns their versions
+func (m *Manager) Installed(t transport.Transport, names []string) (map[string]string, error) {
+	installed := make(map[string]string)
+	if len(names) == 0 {
+		return installed, nil
+	}
+
+	output, err := t.Run(m.query + " " + quote(names) + " 2>/dev/null || true")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if name, version, ok := m.parse(fields, names); ok {
+			installed[name] = version
+		}
+	}
+
+	return installed, nil
+}
+
+// Asks the target which packages are held
+func (m *Manager) Held(t transport.Transport) (map[string]bool, error) {
+	held := make(map[string]bool)
+	if m.held == "" {
+		return held, nil
+	}
+
+	output, err := t.Run(m.held)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range strings.Fields(output) {
+		held[name] = true
+	}
+
+	return held, nil
+}
+
+// Works out what has to change on the target for it to have the packages a spec wants
+func (m *Manager) Compare(t transport.Transport, state State) (Changes, error) {
+	var changes Changes
+
+	if len(state.Held) > 0 && m.hold == "" {
+		return changes, fmt.Errorf("holding packages isn't supported with %s", m.name)
+	}
+	if err := m.CheckPins(state.Install); err != nil {
+		return changes, err
+	}
+
+	wanted := make([]Package, 0, len(state.Install))
+	var names []string
+	for _, entry := range state.Install {
+		pkg := ParsePackage(entry)
+		wanted = append(wanted, pkg)
+		names = append(names, pkg.Name)
+	}
+	names = append(names, state.Absent...)
+
+	installed, err := m.Installed(t, names)
+	if err != nil {
+		return changes, err
+	}
+
+	for _, pkg := range wanted {
+		version, ok := installed[pkg.Name]
+		switch {
+		case !ok:
+			changes.Install = append(changes.Install, pkg)
+		case !pkg.Matches(version):
+			changes.Upgrade = append(changes.Upgrade, pkg)
+		default:
+			changes.Unchanged++
+		}
+	}
+
+	for _, name := range state.Absent {
+		if _, ok := installed[name]; ok {
+			changes.Remove = append(changes.Remove, name)
+		} else {
+			changes.Unchanged++
+		}
+	}
+
+	if len(state.Held) > 0 {
+		held, err := m.Held(t)
+		if err != nil {
+			return changes, err
+		}
+		for _, name := range state.Held {
+			if held[name] {
+				changes.Unchanged++
+			} else {
+				changes.Hold = append(changes.Hold, name)
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// Returns the commands that make the changes, in order: install and upgrade, then remove, then hold.
+// Returns none when there is nothing to change.
+func (m *Manager) Commands(changes Changes) []string {
+	var cmds []string
+
+	var install []string
+	for _, pkg := range append(append([]Package{}, changes.Install...), changes.Upgrade...) {
+		install = append(install, pkg.format(m.pin))
+	}
+	if len(install) > 0 {
+		cmds = append(cmds, m.update, m.install+" "+quote(install))
+	}
+
+	if len(changes.Remove) > 0 {
+		cmds = append(cmds, m.remove+" "+quote(changes.Remove))
+	}
+
+	if len(changes.Hold) > 0 {
+		cmds = append(cmds, m.hold+" "+quote(changes.Hold))
+	}
+
+	return cmds
+}
+
+// Detects the package manager of a target from its /etc/os-release, by its ID and then each ID_LIKE
+func Detect(t transport.Transport) (*Manager, error) {
+	output, err := t.Run("cat /etc/os-release")
+	if err != nil {
+		return nil, err
+	}
+
+	release := parseOSRelease(output)
+	ids := append([]string{release["ID"]}, strings.Fields(release["ID_LIKE"])...)
+
+	for _, id := range ids {
+		switch {
+		case id == "debian" || id == "ubuntu":
+			return Apt, nil
+		case id == "fedora" || id == "rhel" || id == "centos" || id == "rocky" || id == "almalinux" || id == "amzn":
+			// Older releases of the red hat family only have yum
+			if _, err := t.Run("command -v dnf"); err != nil {
+				return Yum, nil
+			}
+			return Dnf, nil
+		case id == "alpine":
+			return Apk, nil
+		case id == "opensuse" || id == "sles" || id == "suse" || strings.HasPrefix(id, "opensuse-"):
+			return Zypper, nil
+		case id == "arch" || id == "manjaro":
+			return Pacman, nil
+		}
+	}
+
+	if release["ID"] == "" {
+		return nil, fmt.Errorf("unable to detect the package manager, /etc/os-release has no ID")
+	}
+	return nil, fmt.Errorf("unable to detect the package manager of distribution [%s]", release["ID"])
+}
+
+// Reads the KEY=value lines of an os-release file
+func parseOSRelease(output string) map[string]string {
+	release := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if eq := strings.Index(line, "="); eq > 0 && !strings.HasPrefix(line, "#") {
+			release[line[:eq]] = strings.Trim(line[eq+1:], "\"'")
+		}
+	}
+	return release
+}
+
+// Quotes each package for the shell, so that version globs reach the package manager as they are
+func quote(packages []string) string {
+	var quoted []string
+	for _, pkg := range packages {
+		quoted = append(quoted, transport.Quote(pkg))
+	}
+	return strings.Join(quoted, " ")
+}
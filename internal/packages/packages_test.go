@@ -0,0 +1,119 @@
+package packages_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/praveensastry/cm/internal/packages"
+	"github.com/praveensastry/cm/internal/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetect(t *testing.T) {
+	releases := map[string]*packages.Manager{
+		"ID=ubuntu\nID_LIKE=debian\n":                    packages.Apt,
+		"ID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\n": packages.Dnf,
+		"ID=alpine\n": packages.Apk,
+		"ID=\"opensuse-leap\"\nID_LIKE=\"suse opensuse\"\n": packages.Zypper,
+		"ID=arch\n":                           packages.Pacman,
+		"ID=pop\nID_LIKE=\"ubuntu debian\"\n": packages.Apt,
+	}
+
+	for release, want := range releases {
+		fake := transport.NewFake()
+		fake.Responder = func(cmd string) (string, error) {
+			return release, nil
+		}
+
+		manager, err := packages.Detect(fake)
+		assert.NoError(t, err, release)
+		assert.Equal(t, want.Name(), manager.Name(), release)
+	}
+
+	// Without dnf, the red hat family falls back to yum
+	fake := transport.NewFake()
+	fake.Responder = func(cmd string) (string, error) {
+		if cmd == "command -v dnf" {
+			return "", errors.New("exit status 1")
+		}
+		return "ID=\"centos\"\nVERSION_ID=\"7\"\n", nil
+	}
+	manager, err := packages.Detect(fake)
+	assert.NoError(t, err)
+	assert.Equal(t, "yum", manager.Name())
+
+	fake = transport.NewFake()
+	fake.Responder = func(cmd string) (string, error) {
+		return "ID=plan9\n", nil
+	}
+	_, err = packages.Detect(fake)
+	assert.EqualError(t, err, "unable to detect the package manager of distribution [plan9]")
+}
+
+func TestInstalled(t *testing.T) {
+	outputs := map[*packages.Manager]string{
+		packages.Apt:    "nginx 1.18.0-0ubuntu1 install ok installed\nphp-fpm 7.4 deinstall ok config-files\n",
+		packages.Dnf:    "nginx 1.18.0-2.el8 installed\npackage php-fpm is not installed\n",
+		packages.Apk:    "nginx-1.18.0-r1 x86_64 {nginx} (BSD-2-Clause) [installed]\n",
+		packages.Pacman: "nginx 1.18.0-2\n",
+	}
+
+	for manager, output := range outputs {
+		fake := transport.NewFake()
+		fake.Responder = func(cmd string) (string, error) {
+			return output, nil
+		}
+
+		installed, err := manager.Installed(fake, []string{"nginx", "php-fpm"})
+		assert.NoError(t, err)
+		assert.Len(t, installed, 1, manager.Name())
+		assert.Contains(t, installed["nginx"], "1.18.0", manager.Name())
+	}
+}
+
+func TestCompare(t *testing.T) {
+	fake := transport.NewFake()
+	fake.Responder = func(cmd string) (string, error) {
+		switch {
+		case strings.HasPrefix(cmd, "dpkg-query"):
+			return "nginx 1.16.1-1 install ok installed\ncurl 7.68.0-1 install ok installed\napache2 2.4.41-4 install ok installed\n", nil
+		case cmd == "apt-mark showhold":
+			return "curl\n", nil
+		}
+		return "", nil
+	}
+
+	state := packages.State{
+		Install: []string{"nginx=1.18.*", "curl", "git"},
+		Absent:  []string{"apache2", "telnet"},
+		Held:    []string{"nginx", "curl"},
+	}
+	changes, err := packages.Apt.Compare(fake, state)
+	assert.NoError(t, err)
+	assert.Equal(t, []packages.Package{{Name: "git"}}, changes.Install)
+	assert.Equal(t, []packages.Package{{Name: "nginx", Version: "1.18.*"}}, changes.Upgrade)
+	assert.Equal(t, []string{"apache2"}, changes.Remove)
+	assert.Equal(t, []string{"nginx"}, changes.Hold)
+	assert.Equal(t, 3, changes.Unchanged)
+
+	cmds := packages.Apt.Commands(changes)
+	assert.Len(t, cmds, 4)
+	assert.Equal(t, "sudo apt-get install -y -f --assume-yes --allow-unauthenticated 'git' 'nginx=1.18.*'", cmds[1])
+	assert.Equal(t, "sudo apt-get purge -y 'apache2'", cmds[2])
+	assert.Equal(t, "sudo apt-mark hold 'nginx'", cmds[3])
+
+	// Nothing to do, nothing to run
+	assert.Empty(t, packages.Apt.Commands(packages.Changes{}))
+
+	_, err = packages.Apk.Compare(fake, packages.State{Held: []string{"nginx"}})
+	assert.EqualError(t, err, "holding packages isn't supported with apk")
+
+	// pacman can't install a version of a package, only the one in its repositories
+	_, err = packages.Pacman.Compare(fake, packages.State{Install: []string{"curl", "nginx=1.18.0-1"}})
+	assert.EqualError(t, err, "pinning package versions isn't supported with pacman, unable to install [nginx=1.18.0-1]")
+	changes, err = packages.Pacman.Compare(fake, packages.State{Install: []string{"nginx"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sudo pacman -Sy --noconfirm", "sudo pacman -S --noconfirm --needed 'nginx'"}, packages.Pacman.Commands(changes))
+	assert.NoError(t, packages.Apk.CheckPins([]string{"nginx=1.18.0-r1"}))
+}
@@ -0,0 +1,62 @@
+package packages
+
+import (
+	"path"
+	"strings"
+)
+
+// A package as listed in a spec, optionally pinned to versions matching a glob, eg: nginx=1.18.*
+type Package struct {
+	Name    string
+	Version string
+}
+
+// Parses a package entry, name=version pins a version
+func ParsePackage(entry string) Package {
+	if eq := strings.Index(entry, "="); eq > 0 {
+		return Package{Name: entry[:eq], Version: entry[eq+1:]}
+	}
+	return Package{Name: entry}
+}
+
+func (p Package) String() string {
+	return p.format("=")
+}
+
+// Checks whether an installed version satisfies the pin, any version does without one
+func (p Package) Matches(version string) bool {
+	if p.Version == "" || p.Version == version {
+		return true
+	}
+	matched, err := path.Match(p.Version, version)
+	return err == nil && matched
+}
+
+// Joins the name and version the way a package manager expects them
+func (p Package) format(pin string) string {
+	if p.Version == "" {
+		return p.Name
+	}
+	return p.Name + pin + p.Version
+}
+
+// The packages a spec wants on its target
+type State struct {
+	Install []string // entries, see ParsePackage
+	Absent  []string // removed when installed
+	Held    []string // kept at their installed version
+}
+
+// What has to change on a target for it to reach a State
+type Changes struct {
+	Install   []Package // not installed yet
+	Upgrade   []Package // installed, but not at a version the pin allows
+	Remove    []string
+	Hold      []string
+	Unchanged int // packages already as wanted
+}
+
+// Checks whether there is nothing to change
+func (c Changes) Empty() bool {
+	return len(c.Install) == 0 && len(c.Upgrade) == 0 && len(c.Remove) == 0 && len(c.Hold) == 0
+}
@@ -0,0 +1,160 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// A pre or post-configure command, with the guards that decide whether it needs to run. Guards go
+// before the command as key=value pairs, values with spaces in single or double quotes, eg:
+//
+//	creates=/etc/nginx/dhparam.pem timeout=5m openssl dhparam -out /etc/nginx/dhparam.pem 2048
+type Command struct {
+	Run     string
+	Creates string        // skipped when this path exists on the target
+	Unless  string        // skipped when this check succeeds on the target
+	OnlyIf  string        // skipped unless this check succeeds on the target
+	Timeout time.Duration // killed after this long, no limit when 0
+	Dir     string        // runs in this folder, the command and its checks alike
+}
+
+// Reads a comma separated list of commands from the COMMANDS section. Commas inside single or
+// double quotes don't separate commands, so guards like unless='grep -q "a, b" file' stay whole.
+func readCommands(cfg *ini.File, key string) []string {
+	section, err := cfg.GetSection("COMMANDS")
+	if err != nil || !section.HasKey(key) {
+		return nil
+	}
+
+	var commands []string
+	var current strings.Builder
+	var quote rune
+	for _, r := range section.Key(key).String() {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == ',':
+			commands = append(commands, strings.TrimSpace(current.String()))
+			current.Reset()
+			continue
+		}
+		current.WriteRune(r)
+	}
+	commands = append(commands, strings.TrimSpace(current.String()))
+
+	return commands
+}
+
+// Reads the guards off the front of a command
+func ParseCommand(entry string) (Command, error) {
+	var command Command
+	rest := strings.TrimSpace(entry)
+
+	for {
+		eq := strings.Index(rest, "=")
+		space := strings.IndexAny(rest, " \t")
+		if eq <= 0 || (space >= 0 && space < eq) {
+			break
+		}
+
+		key := rest[:eq]
+		var field *string
+		switch key {
+		case "creates":
+			field = &command.Creates
+		case "unless":
+			field = &command.Unless
+		case "onlyif":
+			field = &command.OnlyIf
+		case "dir":
+			field = &command.Dir
+		case "timeout":
+			field = new(string)
+		default:
+			// An assignment the command starts with, eg: DEBIAN_FRONTEND=noninteractive
+			field = nil
+		}
+		if field == nil {
+			break
+		}
+
+		value, remaining, err := readValue(rest[eq+1:])
+		if err != nil {
+			return command, fmt.Errorf("%s of command [%s]: %s", key, entry, err)
+		}
+		if key == "timeout" {
+			if command.Timeout, err = parseTimeout(value); err != nil {
+				return command, fmt.Errorf("timeout [%s] of command [%s] should be a number of seconds or a duration like 5m", value, entry)
+			}
+		} else {
+			*field = value
+		}
+		rest = strings.TrimSpace(remaining)
+	}
+
+	if rest == "" {
+		return command, fmt.Errorf("command [%s] has guards but nothing to run", entry)
+	}
+	command.Run = rest
+
+	return command, nil
+}
+
+// Reads a value that is either quoted or runs up to the next space, and returns what follows it
+func readValue(s string) (string, string, error) {
+	if s != "" && (s[0] == '\'' || s[0] == '"') {
+		end := strings.IndexByte(s[1:], s[0])
+		if end < 0 {
+			return "", "", fmt.Errorf("missing closing %c", s[0])
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+
+	if end := strings.IndexAny(s, " \t"); end >= 0 {
+		return s[:end], s[end:], nil
+	}
+	return s, "", nil
+}
+
+// Reads a timeout given in seconds, or as a duration like 90s or 5m
+func parseTimeout(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid timeout")
+	}
+	return timeout, nil
+}
+
+// Checks whether the command has anything deciding whether it runs
+func (c Command) Guarded() bool {
+	return c.Creates != "" || c.Unless != "" || c.OnlyIf != ""
+}
+
+// Describes the command along with its guards, eg: for a plan
+func (c Command) String() string {
+	var guards []string
+	if c.Creates != "" {
+		guards = append(guards, "creates "+c.Creates)
+	}
+	if c.Unless != "" {
+		guards = append(guards, "unless "+c.Unless)
+	}
+	if c.OnlyIf != "" {
+		guards = append(guards, "only if "+c.OnlyIf)
+	}
+	if len(guards) == 0 {
+		return c.Run
+	}
+	return c.Run + " (" + strings.Join(guards, ", ") + ")"
+}
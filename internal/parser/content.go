@@ -0,0 +1,115 @@
+package parser
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/praveensastry/cm/internal/content"
+)
+
+// Content sources that are fetched before a run, rather than read from next to the spec
+var remoteSources = map[string]bool{
+	"git":     true,
+	"archive": true,
+	"http":    true,
+}
+
+// Fetches the remote content of a spec and everything it requires into the cache, so that its
+// files can be transferred. Content is only fetched once, however many jobs prepare the same spec.
+func (s *SpecList) PrepareContent(specName, cacheDir string) error {
+	s.fetch.Lock()
+	defer s.fetch.Unlock()
+
+	for _, spec := range s.order(specName) {
+		if spec.Content.DebianRoot == "" || !remoteSources[spec.Content.Source] || spec.contentRoot != "" {
+			continue
+		}
+		if err := spec.fetchContent(cacheDir); err != nil {
+			return fmt.Errorf("unable to fetch the content of spec [%s]: %s", spec.Name, err)
+		}
+	}
+	return nil
+}
+
+// Returns where the remote content of a spec and everything it requires came from
+func (s *SpecList) ContentOrigins(specName string) []string {
+	var origins []string
+	for _, spec := range s.order(specName) {
+		if spec.contentOrigin != "" {
+			origins = append(origins, spec.contentOrigin)
+		}
+	}
+	return origins
+}
+
+// Checks that a spec and everything it requires were fetched fresh. Specs from a git source or with
+// git content at a branch or tag that couldn't be fetched come from a cached clone, which may be
+// behind; that is fine for listing and validating them, but not for configuring servers with them.
+func (s *SpecList) CheckFetched(specName string) error {
+	for _, spec := range s.order(specName) {
+		if spec.stale != nil {
+			return spec.stale
+		}
+	}
+	return nil
+}
+
+// Fetches the remote content of a single spec
+func (spec *Spec) fetchContent(cacheDir string) error {
+	switch spec.Content.Source {
+	case "git":
+		if spec.Content.Repo == "" {
+			return fmt.Errorf("source is git, but there is no repo")
+		}
+		checkout, err := content.FetchGit(cacheDir, spec.Content.Repo, spec.Content.Ref)
+		if err != nil {
+			return err
+		}
+		spec.contentRoot = filepath.Join(checkout.Dir, filepath.FromSlash(spec.Content.Subdir))
+		spec.contentOrigin = spec.Name + ": " + checkout.Origin(spec.Content.Repo)
+		spec.contentCommit = checkout.Commit
+		if checkout.Stale != nil {
+			spec.stale = fmt.Errorf("the git content of spec [%s] couldn't be fetched, its cached clone at %s may be out of date: %s", spec.Name, checkout.Commit, checkout.Stale)
+		}
+	case "archive":
+		if spec.Content.Archive == "" {
+			return fmt.Errorf("source is archive, but there is no archive")
+		}
+		unpacked, err := content.FetchArchive(cacheDir, spec.archivePath(), spec.Content.Sha256)
+		if err != nil {
+			return err
+		}
+		spec.contentRoot = filepath.Join(unpacked.Dir, filepath.FromSlash(spec.Content.Subdir))
+		spec.contentOrigin = spec.Name + ": " + spec.Content.Archive + " with sha256 " + unpacked.Sha256
+	case "http":
+		if spec.Content.URL == "" {
+			return fmt.Errorf("source is http, but there is no url")
+		}
+		unpacked, err := content.FetchHTTP(cacheDir, spec.Content.URL, spec.Content.Sha256)
+		if err != nil {
+			return err
+		}
+		spec.contentRoot = filepath.Join(unpacked.Dir, filepath.FromSlash(spec.Content.Subdir))
+		spec.contentOrigin = spec.Name + ": " + spec.Content.URL + " with sha256 " + unpacked.Sha256
+	}
+	return nil
+}
+
+// Returns the path of the archive of a spec, which is relative to the spec unless absolute
+func (spec *Spec) archivePath() string {
+	if filepath.IsAbs(spec.Content.Archive) {
+		return spec.Content.Archive
+	}
+	return filepath.Join(spec.SpecRoot, spec.Content.Archive)
+}
+
+// Returns the folder the content of a spec is transferred from, empty when there is none yet
+func (spec *Spec) contentFolder() string {
+	switch {
+	case spec.Content.Source == "spec":
+		return spec.SpecRoot + "/content/"
+	case spec.contentRoot != "":
+		return filepath.Clean(spec.contentRoot) + "/"
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// A named set of commands that only runs when a file it watches was changed by the run,
+// declared in a spec as a [HANDLERS.<name>] section
+type Handler struct {
+	Name     string   `ini:"-"`
+	Commands []string `ini:"command,omitempty"`
+	Watch    []string `ini:"watch,omitempty"` // destination globs, see MatchPath
+}
+
+// Reads every [HANDLERS.<name>] section of a spec
+func readHandlers(cfg *ini.File) ([]Handler, error) {
+	var handlers []Handler
+
+	for _, section := range cfg.Sections() {
+		if !strings.HasPrefix(section.Name(), "HANDLERS.") {
+			continue
+		}
+
+		handler := Handler{Name: strings.TrimPrefix(section.Name(), "HANDLERS.")}
+		if err := section.MapTo(&handler); err != nil {
+			return nil, err
+		}
+		handlers = append(handlers, handler)
+	}
+
+	return handlers, nil
+}
+
+// Checks whether a handler watches any of the given destinations
+func (h *Handler) Notified(changed []string) bool {
+	for _, destination := range changed {
+		for _, pattern := range h.Watch {
+			if MatchPath(pattern, destination) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the handlers of a spec and everything it requires. A handler declared by more than one
+// spec only runs once, the same way duplicate post-configure commands do. When they declare it
+// differently, the spec required first wins and Validate reports the conflict.
+func (s *SpecList) Handlers(specName string) []Handler {
+	return s.getHandlers(specName)
+}
+
+// Unexported func for Handlers
+func (s *SpecList) getHandlers(specName string) []Handler {
+	var handlers []Handler
+	seen := make(map[string]bool)
+
+	// Gather the handlers of each spec, requirements first
+	for _, spec := range s.order(specName) {
+		for _, handler := range spec.Handlers {
+			if !seen[handler.Name] {
+				seen[handler.Name] = true
+				handlers = append(handlers, handler)
+			}
+		}
+	}
+
+	return handlers
+}
+
+// A handler that two specs of the same run declare with different commands or watches
+type handlerConflict struct {
+	name          string
+	first, second *Spec
+}
+
+func (c handlerConflict) Error() string {
+	return fmt.Sprintf("specs [%s] and [%s] both declare handler [%s], with different commands or watches", c.first.Name, c.second.Name, c.name)
+}
+
+// Finds the handlers declared differently by the specs in a dependency order, since only one of them would run
+func handlerConflicts(order []*Spec) []handlerConflict {
+	var conflicts []handlerConflict
+	declared := make(map[string]*Spec)
+
+	for _, spec := range order {
+		for _, handler := range spec.Handlers {
+			first, ok := declared[handler.Name]
+			if !ok {
+				declared[handler.Name] = spec
+				continue
+			}
+			for _, other := range first.Handlers {
+				if other.Name == handler.Name && (!reflect.DeepEqual(other.Commands, handler.Commands) || !reflect.DeepEqual(other.Watch, handler.Watch)) {
+					conflicts = append(conflicts, handlerConflict{name: handler.Name, first: first, second: spec})
+				}
+			}
+		}
+	}
+
+	return conflicts
+}
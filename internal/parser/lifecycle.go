@@ -0,0 +1,90 @@
+package parser
+
+// The steps a job runs to apply a spec, in the order they run
+type Lifecycle struct {
+	Name      string // the spec the steps belong to, empty when they span a whole REQUIRES tree
+	PreCmds   []string
+	Packages  map[string][]string // by package manager, the job installs the list for the manager of its target
+	Absent    []string
+	Held      []string
+	Transfers *FileTransfers
+	PostCmds  []string
+	Services  []Service // brought to their state after the files, before the post-configure commands
+	Groups    []Group   // created before the users, and the users before the files, so files can be owned by them
+	Users     []User
+	Origins   []string // where the remote content of the specs came from, eg: a repository at a commit
+}
+
+// Returns a lifecycle for each spec in the REQUIRES tree of a spec, in dependency order, so that
+// every spec is fully applied before the specs that require it. When the requested spec sets one
+// of the skip flags, that step is left out of every lifecycle, the same way it is when flattened.
+func (s *SpecList) Lifecycles(specName string) []Lifecycle {
+	order := s.order(specName)
+	if len(order) == 0 {
+		return nil
+	}
+	root := order[len(order)-1]
+
+	var lifecycles []Lifecycle
+	for _, spec := range order {
+		lifecycle := Lifecycle{
+			Name:      spec.Name,
+			Transfers: spec.debianFileTransfers(),
+			Services:  spec.Services,
+			Groups:    spec.Groups,
+			Users:     spec.Users,
+		}
+		if spec.contentOrigin != "" {
+			lifecycle.Origins = []string{spec.contentOrigin}
+		}
+
+		if !root.Commands.SkipPre && !spec.Commands.SkipPre {
+			lifecycle.PreCmds = dedupe(nonEmpty(spec.Commands.Pre))
+		}
+		if !root.Packages.SkipPackages && !spec.Packages.SkipPackages {
+			lifecycle.Packages = spec.Packages.Lists()
+			lifecycle.Absent = spec.Packages.AbsentList()
+			lifecycle.Held = spec.Packages.HeldList()
+		}
+		if !root.Commands.SkipPost && !spec.Commands.SkipPost {
+			lifecycle.PostCmds = dedupe(nonEmpty(spec.Commands.Post))
+		}
+
+		lifecycles = append(lifecycles, lifecycle)
+	}
+
+	return lifecycles
+}
+
+// Returns a single lifecycle for the whole REQUIRES tree of a spec: every pre-configure command,
+// then every package, then every file, then every post-configure command
+func (s *SpecList) FlatLifecycle(specName string) Lifecycle {
+	return Lifecycle{
+		PreCmds:   s.PreCmds(specName),
+		Packages:  s.PackageLists(specName),
+		Absent:    s.AbsentPackages(specName),
+		Held:      s.HeldPackages(specName),
+		Transfers: s.DebianFileTransferList(specName),
+		PostCmds:  s.PostCmds(specName),
+		Services:  s.Services(specName),
+		Groups:    s.Groups(specName),
+		Users:     s.Users(specName),
+		Origins:   s.ContentOrigins(specName),
+	}
+}
+
+// Checks whether the lifecycle has anything for the package manager to do
+func (l Lifecycle) HasPackages() bool {
+	return len(l.Packages) > 0 || len(l.Absent) > 0 || len(l.Held) > 0
+}
+
+// Drops the empty strings from a list
+func nonEmpty(items []string) []string {
+	var kept []string
+	for _, item := range items {
+		if item != "" {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,189 @@
+package parser
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/ini.v1"
+)
+
+// The specs a set of targets was locked to, written by cm lock as cm.lock
+type Lock struct {
+	Specs map[string]LockedSpec
+}
+
+// A spec as it was when it was locked, saved as a section named after the spec
+type LockedSpec struct {
+	Name    string `ini:"-"`
+	Version string `ini:"version"`
+	Origin  string `ini:"origin"`
+	Hash    string `ini:"hash"`             // see Spec.Hash
+	Commit  string `ini:"commit,omitempty"` // the commit git content was fetched at, a ref like main moves on its own
+}
+
+// The folders next to a spec that are part of it
+var specFolders = []string{"configs", "content", "scripts"}
+
+// Returns the sha256 checksum of a spec: its spec file, the files in its configs, content and
+// scripts folders, and its archive when it has one, along with the path of each
+func (spec *Spec) Hash() (string, error) {
+	files := []string{spec.SpecFile}
+	for _, folder := range specFolders {
+		filepath.Walk(filepath.Join(spec.SpecRoot, folder), func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+	if spec.Content.Source == "archive" && spec.Content.Archive != "" {
+		files = append(files, spec.archivePath())
+	}
+	sort.Strings(files[1:])
+
+	hash := sha256.New()
+	for _, file := range files {
+		rel, err := filepath.Rel(spec.SpecRoot, file)
+		if err != nil {
+			rel = file
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s\x00", filepath.ToSlash(rel))
+		_, err = io.Copy(hash, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+		hash.Write([]byte{0})
+	}
+
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), nil
+}
+
+// Locks every spec in the REQUIRES trees of the given specs to the version picked for it, where it
+// was loaded from, its hash and the commit of its git content, which has to be fetched with
+// PrepareContent first. Targets that pick different versions of a spec can't share a lock.
+func (s *SpecList) Lock(specNames ...string) (*Lock, error) {
+	lock := &Lock{Specs: make(map[string]LockedSpec)}
+
+	for _, specName := range specNames {
+		order, err := s.Resolve(specName)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, spec := range order {
+			hash, err := spec.Hash()
+			if err != nil {
+				return nil, fmt.Errorf("unable to hash spec [%s]: %s", spec.Name, err)
+			}
+
+			locked := LockedSpec{Name: spec.Name, Version: spec.version(), Origin: spec.Origin, Hash: hash}
+			if spec.Content.Source == "git" && spec.Content.DebianRoot != "" {
+				if spec.contentCommit == "" {
+					return nil, fmt.Errorf("the git content of spec [%s] has to be fetched before it can be locked", spec.Name)
+				}
+				locked.Commit = spec.contentCommit
+			}
+			if existing, ok := lock.Specs[spec.Name]; ok && existing.Version != locked.Version {
+				return nil, fmt.Errorf("spec [%s] resolves to version %s for one target and %s for another", spec.Name, existing.Version, locked.Version)
+			}
+			lock.Specs[spec.Name] = locked
+		}
+	}
+
+	return lock, nil
+}
+
+// Compares the REQUIRES tree of a spec with the lock, and describes each spec that doesn't match it
+func (s *SpecList) CheckLock(lock *Lock, specName string) ([]string, error) {
+	current, err := s.Lock(specName)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range current.Specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var mismatches []string
+	for _, name := range names {
+		now := current.Specs[name]
+		locked, ok := lock.Specs[name]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, fmt.Sprintf("spec [%s] isn't in the lock", name))
+		case locked.Version != now.Version:
+			mismatches = append(mismatches, fmt.Sprintf("spec [%s] is version %s, but version %s is locked", name, now.Version, locked.Version))
+		case locked.Origin != now.Origin:
+			mismatches = append(mismatches, fmt.Sprintf("spec [%s] is loaded from %s, but was locked from %s", name, now.Origin, locked.Origin))
+		case locked.Hash != now.Hash:
+			mismatches = append(mismatches, fmt.Sprintf("spec [%s] from %s changed since it was locked", name, now.Origin))
+		case locked.Commit != now.Commit:
+			mismatches = append(mismatches, fmt.Sprintf("the git content of spec [%s] is at commit %s, but commit %s is locked", name, now.Commit, locked.Commit))
+		}
+	}
+
+	return mismatches, nil
+}
+
+// Adds the specs of another lock, replacing the ones both have
+func (l *Lock) Merge(other *Lock) {
+	for name, spec := range other.Specs {
+		l.Specs[name] = spec
+	}
+}
+
+// Reads a lock file, nil when there is none
+func ReadLock(file string) (*Lock, error) {
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	cfg, err := ini.Load(file)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &Lock{Specs: make(map[string]LockedSpec)}
+	for _, section := range cfg.Sections() {
+		if section.Name() == ini.DefaultSection {
+			continue
+		}
+		spec := LockedSpec{Name: section.Name()}
+		if err := section.MapTo(&spec); err != nil {
+			return nil, err
+		}
+		lock.Specs[spec.Name] = spec
+	}
+
+	return lock, nil
+}
+
+// Writes the lock to a file, one section per spec in name order
+func (l *Lock) Save(file string) error {
+	var names []string
+	for name := range l.Specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cfg := ini.Empty()
+	for _, name := range names {
+		spec := l.Specs[name]
+		if err := cfg.Section(name).ReflectFrom(&spec); err != nil {
+			return err
+		}
+	}
+
+	return cfg.SaveToIndent(file, "\t")
+}
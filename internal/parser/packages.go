@@ -0,0 +1,65 @@
+package parser
+
+import "strings"
+
+// The package managers a spec can list packages for, by the names the jobs know them by
+var PackageManagers = []string{"apt", "dnf", "yum", "apk", "zypper", "pacman"}
+
+// Returns the packages listed for a package manager, each optionally pinned to a version
+// like nginx=1.18.*. dnf and yum take each other's lists when
+// their own is empty, since they install the same packages.
+func (p Packages) List(manager string) []string {
+	var list []string
+	switch manager {
+	case "apt":
+		list = p.AptGet
+	case "dnf":
+		list = p.Dnf
+		if len(list) == 0 {
+			list = p.Yum
+		}
+	case "yum":
+		list = p.Yum
+		if len(list) == 0 {
+			list = p.Dnf
+		}
+	case "apk":
+		list = p.Apk
+	case "zypper":
+		list = p.Zypper
+	case "pacman":
+		list = p.Pacman
+	}
+
+	return splitPackages(list)
+}
+
+// Returns the packages to remove
+func (p Packages) AbsentList() []string {
+	return splitPackages(p.Absent)
+}
+
+// Returns the packages to hold
+func (p Packages) HeldList() []string {
+	return splitPackages(p.Held)
+}
+
+// Entries can hold more than one package
+func splitPackages(list []string) []string {
+	var packages []string
+	for _, entry := range list {
+		packages = append(packages, strings.Fields(entry)...)
+	}
+	return dedupe(packages)
+}
+
+// Returns every package list that isn't empty, by package manager
+func (p Packages) Lists() map[string][]string {
+	lists := make(map[string][]string)
+	for _, manager := range PackageManagers {
+		if packages := p.List(manager); len(packages) > 0 {
+			lists[manager] = packages
+		}
+	}
+	return lists
+}
@@ -1,58 +1,92 @@
 package parser
 
 import (
-	"bytes"
-	"errors"
+	"fmt"
 	"os"
-	"os/exec"
 	"os/user"
 	"path"
 	"path/filepath"
 	"strings"
 	"sync"
-	"time"
 
 	gotree "github.com/DiSiqueira/GoTree"
-	"github.com/hashicorp/hil"
-	"github.com/hashicorp/hil/ast"
 	"github.com/olekukonko/tablewriter"
+	"github.com/praveensastry/cm/internal/content"
 	"github.com/praveensastry/cm/terminal"
 
 	"gopkg.in/ini.v1"
 )
 
 type SpecList struct {
-	Specs map[string]*Spec
+	Specs    map[string]*Spec   // the highest version of each spec
+	Versions map[string][]*Spec // every version of each spec, highest first
+
+	Shadowed []Shadowed // specs hidden by a spec of the same name and version from a later source
+
+	files []string   // every spec file found, including the ones that failed to load
+	fetch sync.Mutex // held while remote content is fetched, jobs running in parallel share the list
 }
 
 type Spec struct {
-	Version  string   `ini:"VERSION"`
-	Requires []string `ini:"REQUIRES,omitempty"`
-	Packages Packages `ini:"PACKAGES"`
-	Configs  Configs  `ini:"CONFIGS"`
-	Content  Content  `ini:"CONTENT"`
-	Commands Commands `ini:"COMMANDS"`
-	SpecFile string   `ini:"-"`
-	SpecRoot string   `ini:"-"`
+	Name        string            `ini:"NAME"`
+	Version     string            `ini:"VERSION"`
+	Requires    []string          `ini:"REQUIRES,omitempty"`
+	Packages    Packages          `ini:"PACKAGES"`
+	Configs     Configs           `ini:"CONFIGS"`
+	Content     Content           `ini:"CONTENT"`
+	Commands    Commands          `ini:"COMMANDS"`
+	Permissions []Permission      `ini:"-"`
+	Handlers    []Handler         `ini:"-"`
+	Services    []Service         `ini:"-"`
+	Groups      []Group           `ini:"-"`
+	Users       []User            `ini:"-"`
+	Vars        map[string]string `ini:"-"` // defaults for ${var.name}, from the [VARS] section
+	SpecFile    string            `ini:"-"`
+	SpecRoot    string            `ini:"-"`
+	Origin      string            `ini:"-"` // the folder or spec source the spec was loaded from
+
+	contentRoot   string // where remote content was fetched to, see PrepareContent
+	contentOrigin string // where remote content came from, eg: the repository and commit
+	contentCommit string // the commit git content was checked out at
+	stale         error  // why its spec source or git content couldn't be fetched, when a cached clone was used instead
 }
 
 type Packages struct {
 	AptGet       []string `ini:"apt_get"`
+	Dnf          []string `ini:"dnf,omitempty"`
+	Yum          []string `ini:"yum,omitempty"`
+	Apk          []string `ini:"apk,omitempty"`
+	Zypper       []string `ini:"zypper,omitempty"`
+	Pacman       []string `ini:"pacman,omitempty"`
+	Absent       []string `ini:"absent,omitempty"` // removed from the target when installed
+	Held         []string `ini:"held,omitempty"`   // kept at their installed version
 	SkipPackages bool     `ini:"skip_packages"`
 }
 
 type Configs struct {
 	DebianRoot      string `ini:"debian_root"`
 	SkipInterpolate bool   `ini:"skip_interpolate"`
+	Owner           string `ini:"owner"`
+	Group           string `ini:"group"`
+	Mode            string `ini:"mode"`
 }
 
 type Content struct {
-	Source     string `ini:"source"`
+	Source     string `ini:"source"` // spec for the content/ folder next to the spec, git, archive or http
 	DebianRoot string `ini:"debian_root"`
+	Owner      string `ini:"owner"`
+	Group      string `ini:"group"`
+	Mode       string `ini:"mode"`
+	Repo       string `ini:"repo"`    // the repository of git content
+	Ref        string `ini:"ref"`     // the branch, tag or commit of git content, the default branch when empty
+	Archive    string `ini:"archive"` // the tarball or zip of archive content, relative to the spec
+	URL        string `ini:"url"`     // where http content is downloaded from, a tarball or zip
+	Sha256     string `ini:"sha256"`  // the checksum of the archive, required for http content
+	Subdir     string `ini:"subdir"`  // the folder of the repository or archive to transfer, all of it when empty
 }
 
 type Commands struct {
-	Pre      []string `ini:"pre,omitempty"`
+	Pre      []string `ini:"pre,omitempty"` // Read again by readCommands, since guards may have commas in their quotes
 	Post     []string `ini:"post,omitempty"`
 	SkipPre  bool     `ini:"skip_pre"`
 	SkipPost bool     `ini:"skip_post"`
@@ -64,9 +98,15 @@ type SpecSummary struct {
 	Name      string
 	Requires  []string
 	PreCmds   []string
-	AptCmds   []string
+	Packages  map[string][]string
+	Absent    []string
+	Held      []string
 	Transfers *FileTransfers
 	PostCmds  []string
+	Handlers  []Handler
+	Services  []Service
+	Groups    []Group
+	Users     []User
 }
 
 type FileTransfer struct {
@@ -76,56 +116,79 @@ type FileTransfer struct {
 	Chown       string
 	Chmod       string
 	Interpolate bool
-}
-
-// Jobs that run locally
-type LocalJob struct {
-	Class       string
-	Sequence    string
-	Locale      string
-	Deltas      chan string
-	Notices     chan string
-	Responses   chan string
-	Information chan string
-	Errors      chan error
-	SpecName    string
-	SpecList    *SpecList
-	WaitGroup   *sync.WaitGroup
+	Contents    []byte // uploaded instead of the Source when set, eg: an authorized_keys file built from several keys
 }
 
 type FileTransfers []FileTransfer
 
-// Reads in all the specs and builds a SpecList
+// Reads in all the specs and builds a SpecList. The spec sources of ~/.cmconfig come first,
+// then ~/.cmspecs/ and ./specs/, each taking precedence over the ones before it.
 func GetSpecs() (*SpecList, error) {
 
-	var err error
-	specList := new(SpecList)
-	specList.Specs = make(map[string]*Spec)
-
 	currentUser, _ := user.Current()
-	// To-Do add support for remote specs
-	candidates := []string{
-		currentUser.HomeDir + "/.cmspecs/",
-		"./specs/",
+	sources, err := ReadSpecSources(currentUser.HomeDir + "/.cmconfig")
+	if err != nil {
+		err = fmt.Errorf("unable to read ~/.cmconfig: %s", err)
 	}
 
-	walkFn := func(path string, fileInfo os.FileInfo, inErr error) (err error) {
-		if inErr == nil && !fileInfo.IsDir() && strings.HasSuffix(strings.ToLower(fileInfo.Name()), ".spec") {
-			err = specList.scanFile(path)
-		}
-		return
+	sources = append(sources,
+		SpecSource{Name: "~/.cmspecs", Type: "dir", Path: currentUser.HomeDir + "/.cmspecs/"},
+		SpecSource{Name: "./specs", Type: "dir", Path: "./specs/"},
+	)
+
+	specList, loadErr := LoadSources(content.CacheDir(), sources...)
+	if err == nil {
+		err = loadErr
 	}
+	return specList, err
+}
+
+// Reads in the specs found in the given folders, later folders overwrite specs of the same name and version
+func LoadSpecs(folders ...string) (*SpecList, error) {
+	var specFolders []specFolder
+	for _, folder := range folders {
+		specFolders = append(specFolders, specFolder{dir: folder, origin: folder})
+	}
+	return loadFolders(specFolders)
+}
+
+// A folder of specs, and where they came from
+type specFolder struct {
+	dir    string
+	origin string
+	stale  error // set when the folder is a cached clone that couldn't be fetched
+}
+
+// Reads in the specs found in the given folders, later folders overwrite specs of the same name and version
+func loadFolders(folders []specFolder) (*SpecList, error) {
+
+	var err error
+	specList := new(SpecList)
+	specList.Specs = make(map[string]*Spec)
+	specList.Versions = make(map[string][]*Spec)
 
 	// Walk each of the candidate folders
-	for _, folder := range candidates {
-		err = filepath.Walk(folder, walkFn)
+	for _, folder := range folders {
+		folder := folder
+
+		// Keep going past a broken spec, so the rest are still loaded, and return the first error
+		walkFn := func(path string, fileInfo os.FileInfo, inErr error) error {
+			if inErr == nil && !fileInfo.IsDir() && strings.HasSuffix(strings.ToLower(fileInfo.Name()), ".spec") {
+				specList.files = append(specList.files, path)
+				if scanErr := specList.scanFile(path, folder); scanErr != nil && err == nil {
+					err = fmt.Errorf("%s: %s", path, scanErr)
+				}
+			}
+			return nil
+		}
+		filepath.Walk(folder.dir, walkFn)
 	}
 
 	return specList, err
 }
 
 // Scans a given file and if it is a spec, adds it to the spec list
-func (s *SpecList) scanFile(file string) error {
+func (s *SpecList) scanFile(file string, folder specFolder) error {
 	// This is most likely a spec file, so lets try to pull a struct from it
 
 	cfg, err := ini.Load(file)
@@ -142,9 +205,30 @@ func (s *SpecList) scanFile(file string) error {
 		if err != nil {
 			return err
 		}
+		spec.Commands.Pre, spec.Commands.Post = readCommands(cfg, "pre"), readCommands(cfg, "post")
+		spec.Permissions = readPermissions(cfg)
+		spec.Vars = readVars(cfg)
+		spec.Handlers, err = readHandlers(cfg)
+		if err != nil {
+			return err
+		}
+		spec.Services, err = readServices(cfg)
+		if err != nil {
+			return err
+		}
+		spec.Groups, err = readGroups(cfg)
+		if err != nil {
+			return err
+		}
+		spec.Users, err = readUsers(cfg, path.Dir(file))
+		if err != nil {
+			return err
+		}
 		spec.SpecFile = file
 		spec.SpecRoot = path.Dir(file)
-		s.Specs[specName] = spec
+		spec.Origin = folder.origin
+		spec.stale = folder.stale
+		s.add(spec)
 	}
 
 	return nil
@@ -158,14 +242,30 @@ func (s *SpecList) SpecExists(spec string) bool {
 	return false
 }
 
-// Returns the apt-get commands for a given spec
-func (s *SpecList) AptGetCmds(specName string) (cmds []string) {
-	packages := s.getAptPackages(specName)
-	if len(packages) > 0 {
-		cmds = []string{"sudo apt-get update -o Dpkg::Options::=\"--force-confdef\" -o Dpkg::Options::=\"--force-confold\"", "sudo apt-get install -y -f --assume-yes --allow-unauthenticated " + strings.Join(packages, " ")}
-	}
+// Returns the packages a spec and its requirements list for a package manager, eg: apt or dnf
+func (s *SpecList) PackageList(specName, manager string) []string {
+	return s.getPackages(specName, func(p Packages) []string { return p.List(manager) })
+}
 
-	return cmds
+// Returns the packages a spec and its requirements want removed
+func (s *SpecList) AbsentPackages(specName string) []string {
+	return s.getPackages(specName, func(p Packages) []string { return p.AbsentList() })
+}
+
+// Returns the packages a spec and its requirements want held
+func (s *SpecList) HeldPackages(specName string) []string {
+	return s.getPackages(specName, func(p Packages) []string { return p.HeldList() })
+}
+
+// Returns the package lists of a spec and its requirements, by package manager
+func (s *SpecList) PackageLists(specName string) map[string][]string {
+	lists := make(map[string][]string)
+	for _, manager := range PackageManagers {
+		if packages := s.PackageList(specName, manager); len(packages) > 0 {
+			lists[manager] = packages
+		}
+	}
+	return lists
 }
 
 // Returns the pre-configure commands
@@ -197,17 +297,21 @@ func (s *SpecList) DebianFileTransferList(specName string) *FileTransfers {
 
 }
 
-// Recursive unexported func for FileTransferList
+// Unexported func for FileTransferList, required specs come first so that a spec can overwrite the files of the specs it requires
 func (s *SpecList) getDebianFileTransfers(specName string) *FileTransfers {
-
-	// The requested spec
-	spec := s.Specs[specName]
 	files := new(FileTransfers)
 
-	if spec == nil {
-		return files
+	for _, spec := range s.order(specName) {
+		*files = append(*files, *spec.debianFileTransfers()...)
 	}
 
+	return files
+}
+
+// Returns the configuration and content files of a single spec
+func (spec *Spec) debianFileTransfers() *FileTransfers {
+	files := new(FileTransfers)
+
 	srcConfFolder := spec.SpecRoot + "/configs/"
 	destConfFolder := spec.Configs.DebianRoot
 	interpolate := true
@@ -220,42 +324,41 @@ func (s *SpecList) getDebianFileTransfers(specName string) *FileTransfers {
 		walkFn := func(path string, fileInfo os.FileInfo, inErr error) (err error) {
 			if inErr == nil && !fileInfo.IsDir() {
 				destination := destConfFolder + strings.TrimPrefix(path, srcConfFolder)
-				files.add(FileTransfer{
+				file := FileTransfer{
 					Source:      path,
 					Destination: destination,
 					Folder:      filepath.Dir(destination),
 					Interpolate: interpolate,
-				})
+				}
+				spec.applyPermissions(&file, spec.Configs.Owner, spec.Configs.Group, spec.Configs.Mode)
+				files.add(file)
 			}
 			return
 		}
 		filepath.Walk(srcConfFolder, walkFn)
 	}
 
-	srcContentFolder := spec.SpecRoot + "/content/"
+	srcContentFolder := spec.contentFolder()
 	destContentFolder := spec.Content.DebianRoot
 
-	if spec.Content.DebianRoot != "" && spec.Content.Source == "spec" {
+	if spec.Content.DebianRoot != "" && srcContentFolder != "" {
 		// Walk the Configs folder and append each file
 		walkFn := func(path string, fileInfo os.FileInfo, inErr error) (err error) {
 			if inErr == nil && !fileInfo.IsDir() {
 				destination := destContentFolder + strings.TrimPrefix(path, srcContentFolder)
-				files.add(FileTransfer{
+				file := FileTransfer{
 					Source:      path,
 					Destination: destination,
 					Folder:      filepath.Dir(destination),
-				})
+				}
+				spec.applyPermissions(&file, spec.Content.Owner, spec.Content.Group, spec.Content.Mode)
+				files.add(file)
 			}
 			return
 		}
 		filepath.Walk(srcContentFolder, walkFn)
 	}
 
-	for _, reqSpec := range spec.Requires {
-		reqFiles := s.getDebianFileTransfers(reqSpec)
-		*files = append(*files, *reqFiles...)
-	}
-
 	return files
 }
 
@@ -269,9 +372,15 @@ func (s *SpecList) ShowSpecBuild(specName string) {
 		Name:      specName,
 		Requires:  s.Requires(specName),
 		PreCmds:   s.PreCmds(specName),
-		AptCmds:   s.AptGetCmds(specName),
+		Packages:  s.PackageLists(specName),
+		Absent:    s.AbsentPackages(specName),
+		Held:      s.HeldPackages(specName),
 		Transfers: s.DebianFileTransferList(specName),
 		PostCmds:  s.PostCmds(specName),
+		Handlers:  s.Handlers(specName),
+		Services:  s.Services(specName),
+		Groups:    s.Groups(specName),
+		Users:     s.Users(specName),
 	})
 }
 
@@ -281,278 +390,66 @@ var SpecBuildTemplate = `
 				  {{ end }}{{ ansi ""}}
 	{{ ansi "bright"}}{{ ansi "fgwhite"}}  pre-configure Commands: {{ ansi ""}}{{ ansi "fgcyan"}}{{ range .PreCmds }}{{ . }}
 				  {{ end }}{{ ansi ""}}
-	{{ ansi "bright"}}{{ ansi "fgwhite"}}        apt-get Commands: {{ ansi ""}}{{ ansi "fgcyan"}}{{ range .AptCmds }}{{ . }}
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}                Packages: {{ ansi ""}}{{ ansi "fgcyan"}}{{ range $manager, $packages := .Packages }}{{ $manager }}: {{ range $packages }}{{ . }} {{ end }}
 				  {{ end }}{{ ansi ""}}
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}         Absent Packages: {{ ansi ""}}{{ ansi "fgcyan"}}{{ range .Absent }}{{ . }} {{ end }}{{ ansi ""}}
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}           Held Packages: {{ ansi ""}}{{ ansi "fgcyan"}}{{ range .Held }}{{ . }} {{ end }}{{ ansi ""}}
 	{{ ansi "bright"}}{{ ansi "fgwhite"}}          File Transfers: {{ ansi ""}}{{ ansi "fgcyan"}}{{range .Transfers}}
 				      Source: {{ .Source }}
 				 Destination: {{ .Destination }}
-				      Folder: {{ .Folder }}
+				      Folder: {{ .Folder }}{{ if .Chown }}
+				       Owner: {{ .Chown }}{{ end }}{{ if .Chmod }}
+				        Mode: {{ .Chmod }}{{ end }}
 				 {{ end }}{{ ansi ""}}
 	{{ ansi "bright"}}{{ ansi "fgwhite"}} post-configure Commands: {{ ansi ""}}{{ ansi "fgcyan"}}{{ range .PostCmds }}{{ . }}
 				  {{ end }}{{ ansi ""}}
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}                Handlers: {{ ansi ""}}{{ ansi "fgcyan"}}{{range .Handlers}}
+				        Name: {{ .Name }}
+				     Watches: {{ range .Watch }}{{ . }} {{ end }}
+				    Commands: {{ range .Commands }}{{ . }}
+				              {{ end }}
+				 {{ end }}{{ ansi ""}}
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}                Services: {{ ansi ""}}{{ ansi "fgcyan"}}{{range .Services}}
+				        Name: {{ .Name }}{{ if .State }}
+				       State: {{ .State }}{{ end }}{{ if .EnabledState }}
+				     At boot: {{ .EnabledState }}{{ end }}{{ if .Watch }}
+				     Watches: {{ range .Watch }}{{ . }} {{ end }}
+				   On change: {{ .ChangeAction }}{{ end }}
+				 {{ end }}{{ ansi ""}}
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}                  Groups: {{ ansi ""}}{{ ansi "fgcyan"}}{{range .Groups}}
+				        Name: {{ .Name }}{{ if .GID }}
+				         GID: {{ .GID }}{{ end }}{{ if .System }}
+				      System: yes{{ end }}
+				 {{ end }}{{ ansi ""}}
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}                   Users: {{ ansi ""}}{{ ansi "fgcyan"}}{{range .Users}}
+				        Name: {{ .Name }}{{ if .UID }}
+				         UID: {{ .UID }}{{ end }}{{ if .Group }}
+				       Group: {{ .Group }}{{ end }}{{ if .Groups }}
+				      Groups: {{ range .Groups }}{{ . }} {{ end }}{{ end }}{{ if .Shell }}
+				       Shell: {{ .Shell }}{{ end }}
+				        Home: {{ .HomeDir }}{{ if .System }}
+				      System: yes{{ end }}{{ if .AuthorizedKeys }}
+				        Keys: {{ range .AuthorizedKeys }}{{ . }} {{ end }}{{ end }}
+				 {{ end }}{{ ansi ""}}
 `
 
-// Run Local configuration on this machine
-func (s *SpecList) LocalConfigure(specName, class, sequence, locale string) {
-
-	var wg sync.WaitGroup
-	wg.Add(1)
-
-	deltas := make(chan string, 10)
-	notices := make(chan string, 10)
-	responses := make(chan string, 10)
-	information := make(chan string, 10)
-	errors := make(chan error, 10)
-
-	job := LocalJob{
-		Deltas:      deltas,
-		Notices:     notices,
-		Responses:   responses,
-		Information: information,
-		Errors:      errors,
-		SpecName:    specName,
-		SpecList:    s,
-		WaitGroup:   &wg,
-		Class:       class,
-		Sequence:    sequence,
-		Locale:      locale,
-	}
-
-	// Launch it!
-	go job.Run()
-
-	// Display Output of Job
-	go func() {
-		for {
-			select {
-			case delta := <-deltas:
-				terminal.Delta(delta)
-			case notice := <-notices:
-				terminal.Notice(notice)
-			case resp := <-responses:
-				terminal.Response(resp)
-			case info := <-information:
-				terminal.Information(info)
-			case err := <-errors:
-				terminal.ErrorLine(err.Error())
-			}
-		}
-	}()
-
-	wg.Wait()
-
-	time.Sleep(time.Second)
-}
-
-func (job *LocalJob) Run() {
-	defer job.WaitGroup.Done()
-
-	// Run pre configure commands
-	preCmds := job.SpecList.PreCmds(job.SpecName)
-	for _, preCmd := range preCmds {
-		job.Deltas <- "Running pre-configuration command: [" + preCmd + "]"
-		_, err := job.runCommand(preCmd, "pre-configuration")
-		if err != nil {
-			job.Errors <- err
-			job.Errors <- errors.New("pre-configuration command: [" + preCmd + "] Failed! Aborting futher tasks for this server..")
-			return
-		}
-		job.Information <- "pre-configuration command: [" + preCmd + "] Succeeded!"
-	}
-
-	// Run Apt-Get Commands
-	aptCmds := job.SpecList.AptGetCmds(job.SpecName)
-	for _, aptCmd := range aptCmds {
-		job.Deltas <- "Running apt-get command: [" + aptCmd + "]"
-		_, err := job.runCommand(aptCmd, "apt-get")
-		if err != nil {
-			job.Errors <- err
-			job.Errors <- errors.New("apt-get command: [" + aptCmd + "] Failed! Aborting futher tasks for this server..")
-			return
-		}
-		job.Information <- "apt-get command: [" + aptCmd + "] Succeeded!"
-	}
-
-	// Transfer any files we need to transfer
-	fileList := job.SpecList.DebianFileTransferList(job.SpecName)
-	if len(*fileList) > 0 {
-		job.Deltas <- "Starting file copy..."
-		err := job.transferFiles(fileList, "Configuration and Content Files")
-		if err != nil {
-			job.Errors <- errors.New("File Copy Failed! Aborting futher tasks for this server..")
-			return
-		}
-		job.Information <- "File Copy Succeeded!"
-	}
-
-	// Run post configure commands
-	postCmds := job.SpecList.PostCmds(job.SpecName)
-	for _, postCmd := range postCmds {
-		job.Deltas <- "Running post-configuration command: [" + postCmd + "]"
-		_, err := job.runCommand(postCmd, "post-configuration")
-		if err != nil {
-			job.Errors <- err
-			job.Errors <- errors.New("post-configuration command: [" + postCmd + "] Failed!")
-		} else {
-			job.Information <- "post-configuration command: [" + postCmd + "] Succeeded!"
-		}
-	}
-}
-
-func (j *LocalJob) runCommand(command string, name string) (string, error) {
-
-	if len(command) > 0 {
-		parts := strings.Fields(command)
-		cmd := exec.Command(parts[0], parts[1:]...)
-
-		var stdoutBuf, stderrBuf bytes.Buffer
-		cmd.Stdout = &stdoutBuf
-		cmd.Stderr = &stderrBuf
-
-		err := cmd.Run()
-
-		// TODO handle more verbose output, maybe from a verbose cli flag
-		if err != nil {
-			j.Responses <- stdoutBuf.String()
-			j.Responses <- stderrBuf.String()
-		}
-
-		return stdoutBuf.String(), err
-	}
-
-	return "empty command!", nil
-
-}
-
-func (j *LocalJob) transferFiles(fileList *FileTransfers, name string) error {
-
-	// Defer cleanup
-	defer j.runCommand("sudo rm -rf /tmp/cm/*", "")
-
-	for _, file := range *fileList {
-
-		// Make our temp folder
-		j.runCommand("mkdir -p /tmp/cm/"+file.Folder, "")
-		_, err := j.runCommand("sudo mkdir -p "+file.Folder, "") // should prob add chown and chmod to the config structs to set it afterwards
-		if err != nil {
-			j.Errors <- errors.New("Unable to make directory: " + file.Folder)
-			return err
-		}
-
-		j.Responses <- "Copying file: " + file.Destination
-
-		// Read the file
-		////////////////..........
-		rf, err := os.Open(file.Source)
-		defer rf.Close()
-		if err != nil {
-			j.Errors <- errors.New("Unable to open file: " + file.Source)
-			return err
-		}
-
-		rfi, err := rf.Stat()
-		if err != nil {
-			j.Errors <- errors.New("Unable to inspect file: " + file.Source)
-			return err
-		}
-
-		fileSize := rfi.Size()
-		fileBytes := make([]byte, fileSize)
-
-		_, err = rf.Read(fileBytes)
-		if err != nil {
-			j.Errors <- errors.New("Unable to read file: " + file.Source)
-			return err
-		}
-
-		var outputFile []byte
-
-		if file.Interpolate {
-
-			j.Deltas <- "Interpolating on file: " + file.Destination
-
-			// Interpolate
-			////////////////..........
-			tree, err := hil.Parse(string(fileBytes))
-			if err != nil {
-				j.Errors <- errors.New("Unable to parse file: " + file.Source)
-				return err
-			}
-
-			config := &hil.EvalConfig{
-				GlobalScope: &ast.BasicScope{
-					VarMap: map[string]ast.Variable{
-						"var.class": ast.Variable{
-							Type:  ast.TypeString,
-							Value: j.Class,
-						},
-						"var.sequence": ast.Variable{
-							Type:  ast.TypeString,
-							Value: j.Sequence,
-						},
-						"var.locale": ast.Variable{
-							Type:  ast.TypeString,
-							Value: j.Locale,
-						},
-						"var.specname": ast.Variable{
-							Type:  ast.TypeString,
-							Value: j.SpecName,
-						},
-					},
-				},
-			}
-
-			result, err := hil.Eval(tree, config)
-			if err != nil {
-				j.Errors <- errors.New("Unable to evaluate file: " + file.Source)
-				return err
-			}
-
-			outputFile = []byte(result.Value.(string))
-		} else {
-
-			j.Notices <- "Skipping Interpolation on file: " + file.Destination
-
-			outputFile = fileBytes
-		}
-
-		// Write the file
-		////////////////..........
-		rf, err = os.Create("/tmp/cm" + file.Destination)
-		defer rf.Close()
-
-		if err != nil {
-			j.Errors <- errors.New("Unable to create file: " + file.Destination)
-			return err
-		}
-		if _, err := rf.Write(outputFile); err != nil {
-			j.Errors <- errors.New("Unable to write file: " + file.Destination)
-			return err
-		}
-
-		// mv
-		j.runCommand("sudo mv /tmp/cm"+file.Destination+" "+file.Destination, "")
-
-		j.Information <- "Completed copy of file: " + file.Destination
-	}
-
-	return nil
-
-}
-
 // Prints table of all available specs in a table
 func (s *SpecList) PrintSpecInformation() {
 	terminal.PrintAnsi(SpecTemplate, s)
 }
 
-var SpecTemplate = `{{range $name, $spec := .Specs}}
+var SpecTemplate = `{{range $name, $versions := .Versions}}{{ range $spec := $versions }}
 {{ansi ""}}{{ ansi "underscore"}}{{ ansi "bright" }}{{ ansi "fgwhite"}}[{{ $name }}]{{ ansi ""}}
-	{{ ansi "bright"}}{{ ansi "fgwhite"}}                Version: {{ ansi ""}}{{ ansi "fgcyan"}}{{ $spec.Version }}{{ ansi ""}}
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}                Version: {{ ansi ""}}{{ ansi "fgcyan"}}{{ $spec.Version }}{{ if ne $spec (index $.Specs $name) }} (not the latest){{ end }}{{ ansi ""}}
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}                 Origin: {{ ansi ""}}{{ ansi "fgcyan"}}{{ $spec.Origin }}{{ ansi ""}}
 	{{ ansi "bright"}}{{ ansi "fgwhite"}}                   Root: {{ ansi ""}}{{ ansi "fgcyan"}}{{ $spec.SpecRoot }}{{ ansi ""}}
 	{{ ansi "bright"}}{{ ansi "fgwhite"}}                   File: {{ ansi ""}}{{ ansi "fgcyan"}}{{ $spec.SpecFile }}{{ ansi ""}}
 
 	{{ ansi "bright"}}{{ ansi "fgwhite"}}               Requires: {{ ansi ""}}{{ ansi "fgcyan"}}{{ range $spec.Requires }}{{ . }} {{ end }}{{ ansi ""}}
-	{{ ansi "bright"}}{{ ansi "fgwhite"}}           Apt Packages: {{ ansi ""}}{{ ansi "fgcyan"}}{{ range $spec.Packages.AptGet }}{{ . }} {{ end }}{{ ansi ""}}
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}               Packages: {{ ansi ""}}{{ ansi "fgcyan"}}{{ range $manager, $packages := $spec.Packages.Lists }}{{ $manager }}: {{ range $packages }}{{ . }} {{ end }}
+				 {{ end }}{{ ansi ""}}
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}        Absent Packages: {{ ansi ""}}{{ ansi "fgcyan"}}{{ range $spec.Packages.Absent }}{{ . }} {{ end }}{{ ansi ""}}
+	{{ ansi "bright"}}{{ ansi "fgwhite"}}          Held Packages: {{ ansi ""}}{{ ansi "fgcyan"}}{{ range $spec.Packages.Held }}{{ . }} {{ end }}{{ ansi ""}}
 
 	{{ ansi "bright"}}{{ ansi "fgwhite"}}    Debian Configs Root: {{ ansi ""}}{{ ansi "fgcyan"}}{{ $spec.Configs.DebianRoot }}{{ ansi ""}}
 
@@ -567,133 +464,123 @@ var SpecTemplate = `{{range $name, $spec := .Specs}}
 
 {{ ansi "fgwhite"}}------------------------------------------------------------------------------------------------
 {{ ansi ""}}
-{{ end }}
+{{ end }}{{ end }}{{ range .Shadowed }}
+{{ ansi "fgyellow"}}[{{ .Spec.Name }}] version {{ .Spec.Version }} from {{ .Spec.Origin }} is shadowed by {{ .By.Origin }}{{ ansi ""}}{{ end }}
 `
 
-// Recursive unexported func for PreCmds
+// Unexported func for PreCmds. A spec that sets skip_pre leaves out its own commands, and when the
+// requested spec sets it, the commands of everything it requires too.
 func (s *SpecList) getPreCommands(specName string) []string {
 	// The requested spec
-	spec := s.Specs[specName]
+	spec := s.root(specName)
 	var commands []string
 	if spec == nil || spec.Commands.SkipPre {
 		return nil
 	}
 
-	// gather all required pre configure commands for this spec
-	for _, pre := range spec.Commands.Pre {
-		if pre != "" {
-			commands = append(commands, pre)
-		}
-	}
-
-	// Loop through this specs requirements to all other pre configure commands we need
-	for _, reqSpec := range spec.Requires {
-		if reqSpec != "" {
-			commands = append(s.getPreCommands(reqSpec), commands...) // prepend
+	// gather the pre configure commands of each spec, requirements first
+	for _, spec := range s.order(specName) {
+		if spec.Commands.SkipPre {
+			continue
 		}
-	}
-
-	// Dedupe, remove later ones
-	for index := 0; index < len(commands); index++ {
-		for compare := index + 1; compare < len(commands); compare++ {
-			if commands[index] == commands[compare] {
-				commands = append(commands[:compare], commands[compare+1:]...)
-				compare--
+		for _, pre := range spec.Commands.Pre {
+			if pre != "" {
+				commands = append(commands, pre)
 			}
 		}
 	}
 
-	return commands
+	return dedupe(commands)
 }
 
 func (s *SpecList) getRequires(specName string) gotree.Tree {
+	// Show the versions that would be used
+	chosen := make(map[string]*Spec)
+	for _, spec := range s.order(specName) {
+		chosen[spec.Name] = spec
+	}
+
+	return s.requiresTree(specName, chosen, make(map[string]bool))
+}
+
+// Builds the tree of requirements below a spec, stopping at any requirement that loops back on itself
+func (s *SpecList) requiresTree(specName string, chosen map[string]*Spec, ancestors map[string]bool) gotree.Tree {
 	// The requested spec
-	spec := s.Specs[specName]
+	spec := chosen[specName]
 	requires := gotree.New(specName)
 
 	if spec == nil {
 		return requires
 	}
 
+	ancestors[specName] = true
+	defer delete(ancestors, specName)
+
 	// gather all requires for this spec
-	for _, req := range spec.Requires {
-		if req != "" && req != "\"\"" {
-			requires.Add(req)
+	reqs, _ := spec.requires()
+	for _, req := range reqs {
+		if ancestors[req.Name] {
+			requires.Add(req.String() + " (cycle)")
+			continue
+		}
 
-			subreqs := s.getRequires(req)
-			if len(subreqs.Items()) > 0 {
-				requires.AddTree(subreqs)
-			}
+		if version := chosen[req.Name]; version != nil {
+			requires.Add(req.String() + " (" + version.version() + ")")
+		} else {
+			requires.Add(req.String() + " (missing)")
+		}
+
+		subreqs := s.requiresTree(req.Name, chosen, ancestors)
+		if len(subreqs.Items()) > 0 {
+			requires.AddTree(subreqs)
 		}
 	}
 
 	return requires
 }
 
-// Recursive unexported func for PostCmds
+// Unexported func for PostCmds, skip_post works the same way as skip_pre
 func (s *SpecList) getPostCommands(specName string) []string {
 	// The requested spec
-	spec := s.Specs[specName]
+	spec := s.root(specName)
 	var commands []string
 
 	if spec == nil || spec.Commands.SkipPost {
 		return nil
 	}
 
-	// gather all required post configure commands for this spec
-	if len(spec.Commands.Post) > 0 {
-		for _, post := range spec.Commands.Post {
-			commands = append(commands, post)
+	// gather the post configure commands of each spec, requirements first
+	for _, spec := range s.order(specName) {
+		if spec.Commands.SkipPost {
+			continue
 		}
-	}
-
-	// Loop through this specs requirements to all other post configure commands we need
-	for _, reqSpec := range spec.Requires {
-		commands = append(s.getPostCommands(reqSpec), commands...) // prepend
-		//commands = append(commands, s.getPostCommands(reqSpec)...) // append
-	}
-
-	// Dedupe, remove later ones
-	for index := 0; index < len(commands); index++ {
-		for compare := index + 1; compare < len(commands); compare++ {
-			if commands[index] == commands[compare] {
-				commands = append(commands[:compare], commands[compare+1:]...)
-				compare--
+		for _, post := range spec.Commands.Post {
+			if post != "" {
+				commands = append(commands, post)
 			}
 		}
 	}
 
-	return commands
+	return dedupe(commands)
 }
 
-// Recursive unexported func for AptGetCmds
-func (s *SpecList) getAptPackages(specName string) []string {
+// Unexported func for the package accessors, skip_packages works the same way as skip_pre
+func (s *SpecList) getPackages(specName string, list func(Packages) []string) []string {
 	// The requested spec
-	spec := s.Specs[specName]
+	spec := s.root(specName)
 	var packages []string
 	if spec == nil || spec.Packages.SkipPackages {
 		return nil
 	}
 
-	// Gather all required apt-get packages for this spec
-	packages = append(packages, spec.Packages.AptGet...)
-
-	// Loop through this specs requirements gather to all other apt-get packages we need
-	for _, reqSpec := range spec.Requires {
-		packages = append(packages, s.getAptPackages(reqSpec)...)
-	}
-
-	// Dedupe
-	for index := 0; index < len(packages); index++ {
-		for compare := index + 1; compare < len(packages); compare++ {
-			if packages[index] == packages[compare] {
-				packages = append(packages[:compare], packages[compare+1:]...)
-				compare--
-			}
+	// Gather the packages of each spec, requirements first
+	for _, spec := range s.order(specName) {
+		if !spec.Packages.SkipPackages {
+			packages = append(packages, list(spec.Packages)...)
 		}
 	}
 
-	return packages
+	return dedupe(packages)
 }
 
 func printTable(header []string, rows [][]string) {
@@ -1,7 +1,13 @@
 package parser_test
 
 import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/praveensastry/cm/internal/parser"
 	"github.com/stretchr/testify/assert"
@@ -11,3 +17,465 @@ func TestGetSpecs(t *testing.T) {
 	_, err := parser.GetSpecs()
 	assert.NoError(t, err)
 }
+
+// Writes a spec file, and any other files under its root, into a temp folder
+func writeSpec(t *testing.T, dir, name, spec string, files map[string]string) {
+	root := filepath.Join(dir, name)
+	assert.NoError(t, os.MkdirAll(root, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, name+".spec"), []byte(spec), 0644))
+
+	for file, contents := range files {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(root, file)), 0755))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(root, file), []byte(contents), 0644))
+	}
+}
+
+func TestFileTransferPermissions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-parser")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeSpec(t, dir, "site", `NAME = site
+
+[CONTENT]
+	source = spec
+	debian_root = "/var/www/"
+	owner = www-data
+	group = www-data
+	mode = 0644
+
+[PERMISSIONS]
+	/var/www/site/uploads/** = mode=660
+	/var/www/site/secret.php = root 0600
+	/var/www/site/index.php = 1000
+	/var/www/site/about.php = 100:755
+	/var/www/site/admin.php = 755 0640
+	/var/www/site/root.php = 0
+`, map[string]string{
+		"content/site/index.php":       "",
+		"content/site/about.php":       "",
+		"content/site/admin.php":       "",
+		"content/site/root.php":        "",
+		"content/site/secret.php":      "",
+		"content/site/uploads/cat.jpg": "",
+	})
+
+	specList, err := parser.LoadSpecs(dir)
+	assert.NoError(t, err)
+
+	permissions := make(map[string]string)
+	for _, file := range *specList.DebianFileTransferList("site") {
+		permissions[file.Destination] = file.Chown + " " + file.Chmod
+	}
+
+	assert.Equal(t, map[string]string{
+		"/var/www/site/index.php":       "1000 0644",
+		"/var/www/site/about.php":       "100:755 0644",
+		"/var/www/site/admin.php":       "755 0640",
+		"/var/www/site/root.php":        "0 0644",
+		"/var/www/site/secret.php":      "root 0600",
+		"/var/www/site/uploads/cat.jpg": "www-data:www-data 660",
+	}, permissions)
+
+	assert.Equal(t, "0750", parser.DirMode("0640"))
+}
+
+func TestResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-parser")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeSpec(t, dir, "site", "NAME = site\nREQUIRES = nginx, php\n[COMMANDS]\npost = site\n", nil)
+	writeSpec(t, dir, "nginx", "NAME = nginx\nREQUIRES = base\n[COMMANDS]\npost = nginx, base\n", nil)
+	writeSpec(t, dir, "php", "NAME = php\nREQUIRES = base\n[COMMANDS]\npost = php\n", nil)
+	writeSpec(t, dir, "base", "NAME = base\nREQUIRES =\n[COMMANDS]\npost = base\n", nil)
+	writeSpec(t, dir, "a", "NAME = a\nREQUIRES = b\n", nil)
+	writeSpec(t, dir, "b", "NAME = b\nREQUIRES = c\n", nil)
+	writeSpec(t, dir, "c", "NAME = c\nREQUIRES = a\n", nil)
+	writeSpec(t, dir, "broken", "NAME = broken\nREQUIRES = missing\n", nil)
+
+	specList, err := parser.LoadSpecs(dir)
+	assert.NoError(t, err)
+
+	order, err := specList.Resolve("site")
+	assert.NoError(t, err)
+	var names []string
+	for _, spec := range order {
+		names = append(names, spec.Name)
+	}
+	assert.Equal(t, []string{"base", "nginx", "php", "site"}, names)
+	assert.Equal(t, []string{"base", "nginx", "php", "site"}, specList.PostCmds("site"))
+
+	_, err = specList.Resolve("b")
+	assert.EqualError(t, err, "spec requirements form a cycle: b -> c -> a -> b")
+	assert.NotPanics(t, func() { specList.PostCmds("a") })
+
+	_, err = specList.Resolve("broken")
+	assert.EqualError(t, err, "unable to find spec [missing], required by [broken]")
+}
+
+func TestResolveVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-parser")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeSpec(t, dir, "nginx-1", "NAME = nginx\nVERSION = 1.18\n", nil)
+	writeSpec(t, dir, "nginx-2", "NAME = nginx\nVERSION = 2.1\n", nil)
+	writeSpec(t, dir, "nginx-10", "NAME = nginx\nVERSION = 10\n", nil)
+	writeSpec(t, dir, "php-7", "NAME = php\nVERSION = 7.4\nREQUIRES = nginx < 10\n", nil)
+	writeSpec(t, dir, "php-8", "NAME = php\nVERSION = 8.0\nREQUIRES = nginx ~> 1.0\n", nil)
+	writeSpec(t, dir, "site", "NAME = site\nREQUIRES = nginx >= 2, php ~> 7\n", nil)
+	writeSpec(t, dir, "legacy", "NAME = legacy\nREQUIRES = nginx, php\n", nil)
+	writeSpec(t, dir, "conflict", "NAME = conflict\nREQUIRES = nginx >= 2, php >= 8\n", nil)
+
+	specList, err := parser.LoadSpecs(dir)
+	assert.NoError(t, err)
+	assert.Len(t, specList.Versions["nginx"], 3)
+	assert.Equal(t, "10", specList.Specs["nginx"].Version)
+
+	versions := func(order []*parser.Spec) []string {
+		var names []string
+		for _, spec := range order {
+			names = append(names, spec.Name+" "+spec.Version)
+		}
+		return names
+	}
+
+	// The highest version that meets every constraint
+	order, err := specList.Resolve("site")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"nginx 2.1", "php 7.4", "site "}, versions(order))
+
+	// php 8 only works with nginx 1.x, which is picked once php 8 has been chosen
+	order, err = specList.Resolve("legacy")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"nginx 1.18", "php 8.0", "legacy "}, versions(order))
+
+	_, err = specList.Resolve("conflict")
+	assert.EqualError(t, err, "no version of spec [nginx] satisfies nginx >= 2 (required by conflict 0) and nginx ~> 1.0 (required by php 8.0), available versions: 10, 2.1, 1.18")
+}
+
+func TestValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-parser")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeSpec(t, dir, "broken", `NAME = broken
+REQUIRES = missing, ok >= 2
+
+[PACKAGES]
+	apt-get = nginx
+
+[CONFIGS]
+	debian_root = /etc
+
+[CONTENT]
+	source = spec
+	debian_root = /var/www/
+
+[EXTRAS]
+	foo = bar
+`, map[string]string{
+		"configs/app.conf": "listen ${var.port",
+	})
+	writeSpec(t, dir, "download", "NAME = download\n\n[CONTENT]\n\tsource = http\n\turl = https://example.com/site.tar.gz\n\tsha256 = abc123\n\tdebian_root = /var/www/\n", nil)
+	writeSpec(t, dir, "ok", "NAME = ok\nVERSION = 1\n", nil)
+	writeSpec(t, dir, "guarded", "NAME = guarded\n\n[COMMANDS]\n\tpre = \"sudo apt-get update, unless='grep -q a, /etc/x' sudo touch /etc/x\"\n\tpost = onlyif='test -f /a, /b' true\n", nil)
+	writeSpec(t, dir, "pinned", "NAME = pinned\n\n[PACKAGES]\n\tapt_get = nginx=1.18.*\n\tpacman = curl, nginx=1.18.0-1\n", nil)
+	writeSpec(t, dir, "unnamed", "VERSION = 1\n", nil)
+
+	specList, err := parser.LoadSpecs(dir)
+	assert.NoError(t, err)
+
+	var problems []string
+	for _, problem := range specList.Validate() {
+		problems = append(problems, strings.TrimPrefix(problem.String(), dir+"/"))
+	}
+
+	assert.Equal(t, []string{
+		"broken/broken.spec:5: unknown key [apt-get] in section [PACKAGES], did you mean [apt_get]?",
+		"broken/broken.spec:14: unknown section [EXTRAS]",
+		"broken/broken.spec:2: requires spec [missing], which doesn't exist",
+		"broken/broken.spec:2: requires [ok >= 2], which no version of spec [ok] satisfies",
+		"broken/broken.spec:8: debian_root [/etc] should end with a /, files are copied to the root followed by their path",
+		"broken/broken.spec:12: debian_root is set, but the content/ folder is missing next to the spec",
+		"broken/configs/app.conf:1: invalid template: expected \"}\" but found end of string",
+		"download/download.spec:4: source is http, but sha256 isn't set to the 64 hex digit checksum of the download",
+		"pinned/pinned.spec:5: pinning package versions isn't supported with pacman, unable to install [nginx=1.18.0-1]",
+		"unnamed/unnamed.spec: missing NAME, the spec will not be loaded",
+	}, problems)
+
+	assert.Empty(t, specList.Validate("ok"))
+	assert.Empty(t, specList.Validate("guarded"))
+	assert.Len(t, specList.Validate("nope"), 1)
+}
+
+func TestHandlerConflicts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-parser")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	reload := "[HANDLERS.reload_nginx]\ncommand = sudo service nginx reload\nwatch = /etc/nginx/**\n"
+	writeSpec(t, dir, "nginx", "NAME = nginx\n"+reload, nil)
+	writeSpec(t, dir, "same", "NAME = same\nREQUIRES = nginx\n"+reload, nil)
+	writeSpec(t, dir, "site", "NAME = site\nREQUIRES = nginx\n\n[HANDLERS.reload_nginx]\ncommand = sudo nginx -s reload\nwatch = /etc/nginx/**\n", nil)
+	writeSpec(t, dir, "app", "NAME = app\nREQUIRES = site\n", nil)
+
+	specList, err := parser.LoadSpecs(dir)
+	assert.NoError(t, err)
+
+	_, err = specList.Resolve("same")
+	assert.NoError(t, err)
+	assert.Len(t, specList.Handlers("same"), 1)
+
+	// Runs aren't stopped by the conflict, the handler of the spec required first runs
+	_, err = specList.Resolve("site")
+	assert.NoError(t, err)
+	if handlers := specList.Handlers("site"); assert.Len(t, handlers, 1) {
+		assert.Equal(t, []string{"sudo service nginx reload"}, handlers[0].Commands)
+	}
+
+	problems := specList.Validate("site", "app")
+	if assert.Len(t, problems, 2) {
+		assert.Equal(t, filepath.Join(dir, "site", "site.spec"), problems[0].File)
+		assert.Equal(t, 4, problems[0].Line)
+		assert.Equal(t, "specs [nginx] and [site] both declare handler [reload_nginx], with different commands or watches", problems[0].Message)
+		assert.Equal(t, filepath.Join(dir, "app", "app.spec"), problems[1].File)
+		assert.Equal(t, 2, problems[1].Line)
+	}
+	assert.Empty(t, specList.Validate("same"))
+}
+
+func TestParseCommand(t *testing.T) {
+	command, err := parser.ParseCommand("creates=/etc/ssl/dhparam.pem timeout=90 dir=\"/etc/ssl\" unless='test -s dhparam.pem' openssl dhparam -out dhparam.pem 2048")
+	assert.NoError(t, err)
+	assert.Equal(t, "openssl dhparam -out dhparam.pem 2048", command.Run)
+	assert.Equal(t, "/etc/ssl/dhparam.pem", command.Creates)
+	assert.Equal(t, "test -s dhparam.pem", command.Unless)
+	assert.Equal(t, "/etc/ssl", command.Dir)
+	assert.Equal(t, 90*time.Second, command.Timeout)
+
+	// Plain commands, and variables the command starts with, are left alone
+	for _, entry := range []string{"sudo apt-get update", "DEBIAN_FRONTEND=noninteractive sudo apt-get upgrade -y", "echo a=b"} {
+		command, err := parser.ParseCommand(entry)
+		assert.NoError(t, err)
+		assert.Equal(t, entry, command.Run)
+		assert.False(t, command.Guarded())
+	}
+
+	for _, entry := range []string{"creates=/tmp/x", "unless='true sudo reboot", "timeout=soon sleep 5"} {
+		_, err := parser.ParseCommand(entry)
+		assert.Error(t, err, entry)
+	}
+}
+
+func TestCommandsWithCommas(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-parser")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeSpec(t, dir, "php", `NAME = php
+[COMMANDS]
+	pre = "sudo apt-get update, unless='grep -q a, /etc/x' sudo touch /etc/x,echo 'one, two'"
+	post = onlyif="test -f /a, /b" true
+`, nil)
+
+	specList, err := parser.LoadSpecs(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sudo apt-get update", "unless='grep -q a, /etc/x' sudo touch /etc/x", "echo 'one, two'"}, specList.PreCmds("php"))
+	assert.Equal(t, []string{`onlyif="test -f /a, /b" true`}, specList.PostCmds("php"))
+
+	command, err := parser.ParseCommand(specList.PreCmds("php")[1])
+	assert.NoError(t, err)
+	assert.Equal(t, "grep -q a, /etc/x", command.Unless)
+}
+
+// Commits everything in a folder, making it a git repository on main first when it isn't one yet
+func commitAll(t *testing.T, repo string) {
+	steps := [][]string{{"add", "-A"}, {"commit", "-q", "-m", "specs"}}
+	if _, err := os.Stat(filepath.Join(repo, ".git")); err != nil {
+		steps = append([][]string{{"init", "-q", "-b", "main"}}, steps...)
+	}
+	for _, args := range steps {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=cm", "GIT_AUTHOR_EMAIL=cm@example.com", "GIT_COMMITTER_NAME=cm", "GIT_COMMITTER_EMAIL=cm@example.com")
+		output, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(output))
+	}
+}
+
+func TestLoadSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-parser")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// A shared library in a git repository, with the specs in a folder of their own
+	library := filepath.Join(dir, "library")
+	writeSpec(t, filepath.Join(library, "specs"), "nginx", "NAME = nginx\nVERSION = 1\n", nil)
+	writeSpec(t, filepath.Join(library, "specs"), "redis", "NAME = redis\nVERSION = 1\n", nil)
+	commitAll(t, library)
+
+	// A local copy of one of its specs
+	writeSpec(t, filepath.Join(dir, "local"), "nginx", "NAME = nginx\nVERSION = 1\n", nil)
+
+	config := "[SPECS.library]\n\ttype = git\n\trepo = file://" + library + "\n\tref = main\n\tsubdir = specs\n\n[SPECS.local]\n\tpath = local\n"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "cmconfig"), []byte(config), 0644))
+
+	sources, err := parser.ReadSpecSources(filepath.Join(dir, "cmconfig"))
+	assert.NoError(t, err)
+	assert.Len(t, sources, 2)
+	assert.Equal(t, filepath.Join(dir, "local"), sources[1].Path, "paths are relative to the config file")
+
+	specList, err := parser.LoadSources(filepath.Join(dir, "cache"), sources...)
+	assert.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(specList.Specs["redis"].Origin, "library: file://"+library+" at "))
+	assert.Equal(t, filepath.Join(dir, "local"), specList.Specs["nginx"].Origin, "later sources take precedence")
+	assert.Len(t, specList.Shadowed, 1)
+	assert.Equal(t, specList.Specs["nginx"], specList.Shadowed[0].By)
+	assert.True(t, strings.HasPrefix(specList.Shadowed[0].Spec.Origin, "library: "))
+	assert.NoError(t, specList.CheckFetched("redis"))
+
+	// A branch that can't be fetched is loaded from the cached clone, but may be out of date
+	assert.NoError(t, os.Rename(library, library+"-moved"))
+	specList, err = parser.LoadSources(filepath.Join(dir, "cache"), sources...)
+	assert.NoError(t, err)
+	assert.True(t, specList.SpecExists("redis"))
+	assert.Error(t, specList.CheckFetched("redis"))
+	assert.NoError(t, specList.CheckFetched("nginx"))
+	assert.NoError(t, os.Rename(library+"-moved", library))
+
+	// A source that can't be fetched is reported, and the rest still load
+	specList, err = parser.LoadSources(filepath.Join(dir, "cache"), parser.SpecSource{Name: "gone", Type: "git", Repo: filepath.Join(dir, "missing")}, sources[1])
+	assert.Error(t, err)
+	assert.True(t, specList.SpecExists("nginx"))
+
+	sources, err = parser.ReadSpecSources(filepath.Join(dir, "missing"))
+	assert.NoError(t, err)
+	assert.Empty(t, sources)
+}
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-parser")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	shared := filepath.Join(dir, "shared")
+	writeSpec(t, shared, "site", "NAME = site\nVERSION = 2\nREQUIRES = nginx\n\n[CONTENT]\n\tsource = spec\n\tdebian_root = /var/www/\n", map[string]string{
+		"content/index.html": "v1\n",
+	})
+	writeSpec(t, shared, "nginx", "NAME = nginx\nVERSION = 1\n", nil)
+
+	specList, err := parser.LoadSpecs(shared)
+	assert.NoError(t, err)
+
+	lock, err := specList.Lock("site")
+	assert.NoError(t, err)
+	assert.Len(t, lock.Specs, 2)
+	assert.Equal(t, "2", lock.Specs["site"].Version)
+	assert.Equal(t, shared, lock.Specs["site"].Origin)
+	assert.True(t, strings.HasPrefix(lock.Specs["site"].Hash, "sha256:"))
+
+	// The lock survives being written and read back
+	file := filepath.Join(dir, "cm.lock")
+	assert.NoError(t, lock.Save(file))
+	lock, err = parser.ReadLock(file)
+	assert.NoError(t, err)
+	mismatches, err := specList.CheckLock(lock, "site")
+	assert.NoError(t, err)
+	assert.Empty(t, mismatches)
+
+	// Editing the content of a spec changes its hash
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(shared, "site", "content", "index.html"), []byte("v2\n"), 0644))
+	mismatches, err = specList.CheckLock(lock, "site")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"spec [site] from " + shared + " changed since it was locked"}, mismatches)
+
+	// A copy in another folder shadows the locked spec
+	writeSpec(t, filepath.Join(dir, "mine"), "nginx", "NAME = nginx\nVERSION = 1\n", nil)
+	specList, err = parser.LoadSpecs(shared, filepath.Join(dir, "mine"))
+	assert.NoError(t, err)
+	mismatches, err = specList.CheckLock(lock, "nginx")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"spec [nginx] is loaded from " + filepath.Join(dir, "mine") + ", but was locked from " + shared}, mismatches)
+
+	lock, err = parser.ReadLock(filepath.Join(dir, "missing.lock"))
+	assert.NoError(t, err)
+	assert.Nil(t, lock)
+}
+
+func TestLockGitContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-parser")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repo := filepath.Join(dir, "site-content")
+	assert.NoError(t, os.MkdirAll(repo, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(repo, "index.html"), []byte("v1\n"), 0644))
+	commitAll(t, repo)
+
+	specs := filepath.Join(dir, "specs")
+	writeSpec(t, specs, "site", "NAME = site\n\n[CONTENT]\n\tsource = git\n\trepo = file://"+repo+"\n\tref = main\n\tdebian_root = /var/www/\n", nil)
+	load := func() *parser.SpecList {
+		specList, err := parser.LoadSpecs(specs)
+		assert.NoError(t, err)
+		return specList
+	}
+
+	// Git content has to be fetched to know which commit to lock
+	specList := load()
+	_, err = specList.Lock("site")
+	assert.EqualError(t, err, "the git content of spec [site] has to be fetched before it can be locked")
+
+	assert.NoError(t, specList.PrepareContent("site", filepath.Join(dir, "cache")))
+	lock, err := specList.Lock("site")
+	assert.NoError(t, err)
+	locked := lock.Specs["site"].Commit
+	assert.Len(t, locked, 40)
+
+	// The spec is unchanged, but main moved on
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(repo, "index.html"), []byte("v2\n"), 0644))
+	commitAll(t, repo)
+	specList = load()
+	assert.NoError(t, specList.PrepareContent("site", filepath.Join(dir, "cache")))
+	mismatches, err := specList.CheckLock(lock, "site")
+	assert.NoError(t, err)
+	if assert.Len(t, mismatches, 1) {
+		assert.Contains(t, mismatches[0], "but commit "+locked+" is locked")
+	}
+}
+
+func TestResolveVars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-parser")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeSpec(t, dir, "site", "NAME = site\nREQUIRES = nginx\n[VARS]\nport = 8080\nroot = /srv/site\n", nil)
+	writeSpec(t, dir, "nginx", "NAME = nginx\nREQUIRES =\n[VARS]\nport = 80\nworkers = 4\n", nil)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "prod.vars"), []byte("workers = 16\nroot = /srv/prod\n"), 0644))
+
+	specList, err := parser.LoadSpecs(dir)
+	assert.NoError(t, err)
+
+	file, err := parser.ReadVarFile(filepath.Join(dir, "prod.vars"))
+	assert.NoError(t, err)
+	flags, err := parser.ParseVarFlags([]string{"root=/srv/override"})
+	assert.NoError(t, err)
+	_, err = parser.ParseVarFlags([]string{"root"})
+	assert.Error(t, err)
+
+	vars := parser.ResolveVars(append(specList.VarLayers("site"), file, flags)...)
+	assert.Equal(t, map[string]string{"port": "8080", "workers": "16", "root": "/srv/override"}, parser.VarValues(vars))
+	assert.Equal(t, "spec site", vars["port"].Source)
+	assert.Equal(t, []string{"spec nginx"}, vars["port"].Overridden)
+	assert.Equal(t, "var file "+filepath.Join(dir, "prod.vars"), vars["workers"].Source)
+	assert.Equal(t, "--var", vars["root"].Source)
+	assert.Equal(t, []string{"spec site", "var file " + filepath.Join(dir, "prod.vars")}, vars["root"].Overridden)
+
+	command, err := parser.InterpolateCommand("cd ${var.root} && echo ${HOME} $${var.port} ${var.port}", parser.VarValues(vars))
+	assert.NoError(t, err)
+	assert.Equal(t, "cd /srv/override && echo ${HOME} ${var.port} 8080", command)
+	_, err = parser.InterpolateCommand("echo ${var.missing}", parser.VarValues(vars))
+	assert.EqualError(t, err, "unknown variable var.missing in command [echo ${var.missing}]")
+}
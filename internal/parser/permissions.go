@@ -0,0 +1,109 @@
+package parser
+
+import (
+	"path"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// An ownership and mode rule from a spec's [PERMISSIONS] section, applied to every
+// transferred file whose destination matches the pattern
+type Permission struct {
+	Pattern string
+	Chown   string // owner, owner:group or :group
+	Chmod   string // octal mode, eg: 0644
+}
+
+// Reads the [PERMISSIONS] section of a spec, where each key is a destination glob and
+// each value is an owner[:group] and/or an octal mode, eg: /var/www/** = www-data:www-data 0640
+func readPermissions(cfg *ini.File) []Permission {
+	var permissions []Permission
+
+	section, err := cfg.GetSection("PERMISSIONS")
+	if err != nil {
+		return nil
+	}
+
+	for _, key := range section.Keys() {
+		permission := Permission{Pattern: strings.Trim(key.Name(), "\"")}
+		for _, field := range strings.Fields(key.String()) {
+			if mode, ok := readMode(field); ok {
+				permission.Chmod = mode
+			} else {
+				permission.Chown = field
+			}
+		}
+		permissions = append(permissions, permission)
+	}
+
+	return permissions
+}
+
+// Builds a chown argument from an owner and a group, either of which may be empty
+func chownSpec(owner, group string) string {
+	if group == "" {
+		return owner
+	}
+	return owner + ":" + group
+}
+
+// Sets the ownership and mode of a file from the section defaults, then any matching rules in order
+func (spec *Spec) applyPermissions(file *FileTransfer, owner, group, mode string) {
+	file.Chown = chownSpec(owner, group)
+	file.Chmod = mode
+
+	for _, permission := range spec.Permissions {
+		if !MatchPath(permission.Pattern, file.Destination) {
+			continue
+		}
+		if permission.Chown != "" {
+			file.Chown = permission.Chown
+		}
+		if permission.Chmod != "" {
+			file.Chmod = permission.Chmod
+		}
+	}
+}
+
+// Matches a destination path against a glob. Besides the usual path.Match syntax,
+// a pattern ending in /** matches everything below that folder.
+func MatchPath(pattern, name string) bool {
+	if strings.HasSuffix(pattern, "/**") {
+		return strings.HasPrefix(name, strings.TrimSuffix(pattern, "**"))
+	}
+
+	matched, _ := path.Match(pattern, name)
+	return matched
+}
+
+// Returns the mode for folders created to hold a file, which is the file mode with the
+// execute bit added wherever the read bit is set, so 0640 becomes 0750
+func DirMode(fileMode string) string {
+	mode, err := strconv.ParseUint(fileMode, 8, 32)
+	if err != nil {
+		return ""
+	}
+
+	for _, read := range []uint64{0400, 0040, 0004} {
+		if mode&read != 0 {
+			mode |= read >> 2
+		}
+	}
+
+	return "0" + strconv.FormatUint(mode, 8)
+}
+
+// Reads a mode from a [PERMISSIONS] value. Modes start with a 0, eg: 0644 or 02775, or are given as
+// mode=644, so numeric owners and groups like 100 or 1000:755 are never mistaken for one
+func readMode(field string) (string, bool) {
+	mode := strings.TrimPrefix(field, "mode=")
+	if mode == field && (!strings.HasPrefix(mode, "0") || len(mode) < 3) {
+		return "", false
+	}
+	if _, err := strconv.ParseUint(mode, 8, 32); err != nil {
+		return "", false
+	}
+	return mode, true
+}
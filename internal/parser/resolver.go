@@ -0,0 +1,180 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Returned when the REQUIRES of some specs loop back on themselves
+type CycleError struct {
+	Path []string // the specs forming the cycle, starting and ending with the same spec
+}
+
+func (e *CycleError) Error() string {
+	return "spec requirements form a cycle: " + strings.Join(e.Path, " -> ")
+}
+
+// Resolves the REQUIRES of a spec into a dependency ordered list of specs. Every spec comes
+// after all of the specs it requires, and the spec itself comes last. The order is deterministic,
+// specs are visited depth first in the order they are listed in REQUIRES. Of the versions of each
+// spec, the highest one that meets the version constraints of every spec requiring it is used.
+func (s *SpecList) Resolve(specName string) ([]*Spec, error) {
+	return s.resolve(specName, true)
+}
+
+// Returns the dependency order of a spec for the accessors. Missing specs and the requirements that
+// close a cycle are skipped, and conflicting constraints are ignored, so that describing a broken spec still shows something; Run resolves strictly first and reports the problem.
+func (s *SpecList) order(specName string) []*Spec {
+	order, _ := s.resolve(specName, false)
+	return order
+}
+
+// Returns the resolved version of a spec, the last one in its order
+func (s *SpecList) root(specName string) *Spec {
+	order := s.order(specName)
+	if len(order) == 0 {
+		return nil
+	}
+	return order[len(order)-1]
+}
+
+func (s *SpecList) resolve(specName string, strict bool) ([]*Spec, error) {
+	// Picking a version changes which constraints apply, so walk the tree again with the
+	// constraints found by the last walk until they settle. Versions that don't meet them
+	// along the way are only a problem if they are still there at the end.
+	var constraints map[string][]Requirement
+	for i := 0; ; i++ {
+		order, found, err := s.walk(specName, constraints, strict)
+		if err != nil {
+			return nil, err
+		}
+		if reflect.DeepEqual(found, constraints) {
+			if strict {
+				if err := s.checkConstraints(order, found); err != nil {
+					return nil, err
+				}
+			}
+			return order, nil
+		}
+		if i > s.count() {
+			if strict {
+				return nil, fmt.Errorf("unable to settle on versions for the requirements of spec [%s]", specName)
+			}
+			return order, nil
+		}
+		constraints = found
+	}
+}
+
+// Walks the REQUIRES tree of a spec depth first, choosing versions with the given constraints.
+// Returns the dependency order along with the constraints placed by the chosen specs.
+func (s *SpecList) walk(specName string, constraints map[string][]Requirement, strict bool) ([]*Spec, map[string][]Requirement, error) {
+	var order []*Spec
+	found := make(map[string][]Requirement)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var path []string
+
+	var visit func(name, requiredBy string) error
+	visit = func(name, requiredBy string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			if !strict {
+				return nil
+			}
+			// Trim the path down to where the cycle starts
+			for i, p := range path {
+				if p == name {
+					return &CycleError{Path: append(append([]string{}, path[i:]...), name)}
+				}
+			}
+		}
+
+		spec := s.choose(name, constraints[name])
+		if spec == nil {
+			if !strict {
+				return nil
+			}
+			if requiredBy == "" {
+				return fmt.Errorf("unable to find a spec named [%s]", name)
+			}
+			return fmt.Errorf("unable to find spec [%s], required by [%s]", name, requiredBy)
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+
+		requires, err := spec.requires()
+		if err != nil && strict {
+			return fmt.Errorf("spec [%s]: %s", name, err)
+		}
+		for _, req := range requires {
+			found[req.Name] = append(found[req.Name], req)
+			if err := visit(req.Name, name); err != nil {
+				return err
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[name] = visited
+		order = append(order, spec)
+
+		return nil
+	}
+
+	if err := visit(specName, ""); err != nil {
+		return nil, nil, err
+	}
+
+	return order, found, nil
+}
+
+// Returns the requirements of a spec, skipping blanks. Entries that can't be parsed are left out
+// and reported in the error.
+func (spec *Spec) requires() ([]Requirement, error) {
+	var requires []Requirement
+	var invalid error
+	for _, entry := range spec.Requires {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || entry == "\"\"" {
+			continue
+		}
+		req, err := ParseRequirement(entry)
+		if err != nil {
+			invalid = err
+			continue
+		}
+		req.RequiredBy = spec.Name + " " + spec.version()
+		requires = append(requires, req)
+	}
+	return requires, invalid
+}
+
+// Counts every version of every spec
+func (s *SpecList) count() int {
+	count := 0
+	for _, versions := range s.Versions {
+		count += len(versions)
+	}
+	return count
+}
+
+// Removes duplicate strings, keeping the first of each
+func dedupe(items []string) []string {
+	var deduped []string
+	seen := make(map[string]bool)
+	for _, item := range items {
+		if !seen[item] {
+			seen[item] = true
+			deduped = append(deduped, item)
+		}
+	}
+	return deduped
+}
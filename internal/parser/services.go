@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// The state a spec wants a service in, declared as a [SERVICES.<name>] section
+type Service struct {
+	Name     string   `ini:"-"`
+	State    string   `ini:"state"`           // running or stopped, left alone when empty
+	Enabled  string   `ini:"enabled"`         // whether it starts at boot, true or false, left alone when empty
+	OnChange string   `ini:"on_change"`       // reload or restart, when a file it watches changes, restart when empty
+	Watch    []string `ini:"watch,omitempty"` // destination globs, see MatchPath
+}
+
+// Reads every [SERVICES.<name>] section of a spec
+func readServices(cfg *ini.File) ([]Service, error) {
+	var services []Service
+
+	for _, section := range cfg.Sections() {
+		if !strings.HasPrefix(section.Name(), "SERVICES.") {
+			continue
+		}
+
+		service := Service{Name: strings.TrimPrefix(section.Name(), "SERVICES.")}
+		if err := section.MapTo(&service); err != nil {
+			return nil, err
+		}
+		services = append(services, service)
+	}
+
+	return services, nil
+}
+
+// Returns enabled or disabled, or empty when the spec leaves it alone
+func (s Service) EnabledState() string {
+	if s.Enabled == "" {
+		return ""
+	}
+	if enabled, err := strconv.ParseBool(s.Enabled); err == nil && !enabled {
+		return "disabled"
+	}
+	return "enabled"
+}
+
+// Returns the action to take when a file the service watches changed
+func (s Service) ChangeAction() string {
+	if s.OnChange == "reload" {
+		return "reload"
+	}
+	return "restart"
+}
+
+// Checks whether the service watches any of the given destinations
+func (s Service) Notified(changed []string) bool {
+	for _, destination := range changed {
+		for _, pattern := range s.Watch {
+			if MatchPath(pattern, destination) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the services of a spec and everything it requires. When more than one spec declares
+// a service, the one closest to the requested spec wins.
+func (s *SpecList) Services(specName string) []Service {
+	return mergeServices(s.order(specName))
+}
+
+// Merges the services of specs in dependency order, later specs replace earlier declarations
+func mergeServices(specs []*Spec) []Service {
+	var services []Service
+	index := make(map[string]int)
+
+	for _, spec := range specs {
+		for _, service := range spec.Services {
+			if i, ok := index[service.Name]; ok {
+				services[i] = service
+				continue
+			}
+			index[service.Name] = len(services)
+			services = append(services, service)
+		}
+	}
+
+	return services
+}
@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/praveensastry/cm/internal/content"
+	"github.com/praveensastry/cm/terminal"
+	"gopkg.in/ini.v1"
+)
+
+// A place specs are loaded from. Besides ~/.cmspecs/ and ./specs/, sources are declared as
+// [SPECS.<name>] sections of ~/.cmconfig
+type SpecSource struct {
+	Name   string `ini:"-"`
+	Type   string `ini:"type"`   // dir, git or http
+	Path   string `ini:"path"`   // the folder of a dir source, relative to the config file unless absolute
+	Repo   string `ini:"repo"`   // the repository of a git source
+	Ref    string `ini:"ref"`    // the branch, tag or commit of a git source, the default branch when empty
+	URL    string `ini:"url"`    // the tarball or zip of an http source
+	Sha256 string `ini:"sha256"` // the checksum of the download of an http source, required
+	Subdir string `ini:"subdir"` // the folder of the repository or download the specs are in, all of it when empty
+}
+
+// A spec hidden by another with the same name and version, from a source with a higher precedence
+type Shadowed struct {
+	Spec *Spec // the spec that isn't used
+	By   *Spec // the spec used instead
+}
+
+// Reads the spec sources declared in a config file, in the order they are declared. A missing file declares none.
+func ReadSpecSources(file string) ([]SpecSource, error) {
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	cfg, err := ini.Load(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []SpecSource
+	for _, section := range cfg.Sections() {
+		if !strings.HasPrefix(section.Name(), "SPECS.") {
+			continue
+		}
+
+		source := SpecSource{Name: strings.TrimPrefix(section.Name(), "SPECS.")}
+		if err := section.MapTo(&source); err != nil {
+			return nil, err
+		}
+		if source.Type == "" {
+			source.Type = "dir"
+		}
+		if source.Type == "dir" && !filepath.IsAbs(source.Path) {
+			source.Path = filepath.Join(filepath.Dir(file), source.Path)
+		}
+		sources = append(sources, source)
+	}
+
+	return sources, nil
+}
+
+// Fetches a source into the cache when it is remote, and returns the folder its specs are in,
+// along with a description of where they came from
+func (source SpecSource) fetch(cacheDir string) (specFolder, error) {
+	switch source.Type {
+	case "dir":
+		return specFolder{dir: source.Path, origin: source.Path}, nil
+	case "git":
+		if source.Repo == "" {
+			return specFolder{}, fmt.Errorf("spec source [%s] is git, but there is no repo", source.Name)
+		}
+		checkout, err := content.FetchGit(cacheDir, source.Repo, source.Ref)
+		if err != nil {
+			return specFolder{}, err
+		}
+		folder := specFolder{dir: filepath.Join(checkout.Dir, filepath.FromSlash(source.Subdir)), origin: source.Name + ": " + checkout.Origin(source.Repo)}
+		if checkout.Stale != nil {
+			terminal.Notice(fmt.Sprintf("Unable to fetch spec source [%s], using the cached clone at %s: %s", source.Name, checkout.Commit, checkout.Stale))
+			folder.stale = fmt.Errorf("spec source [%s] couldn't be fetched, its cached clone at %s may be out of date: %s", source.Name, checkout.Commit, checkout.Stale)
+		}
+		return folder, nil
+	case "http":
+		if source.URL == "" {
+			return specFolder{}, fmt.Errorf("spec source [%s] is http, but there is no url", source.Name)
+		}
+		unpacked, err := content.FetchHTTP(cacheDir, source.URL, source.Sha256)
+		if err != nil {
+			return specFolder{}, err
+		}
+		return specFolder{dir: filepath.Join(unpacked.Dir, filepath.FromSlash(source.Subdir)), origin: source.Name + ": " + source.URL + " with sha256 " + unpacked.Sha256}, nil
+	}
+	return specFolder{}, fmt.Errorf("spec source [%s] has an unknown type [%s], should be dir, git or http", source.Name, source.Type)
+}
+
+// Reads in the specs of each source, fetching the remote ones into the cache. Sources later in
+// the list take precedence: their specs hide specs of the same name and version from earlier
+// sources, which are listed in Shadowed. A source that can't be fetched, and was never cached, is
+// skipped and the rest are still loaded, so that they can be listed and validated. The first error
+// is returned, and has to be treated as fatal by anything configuring servers, as leaving out the
+// specs of a source can change which version of a spec gets picked.
+func LoadSources(cacheDir string, sources ...SpecSource) (*SpecList, error) {
+	var folders []specFolder
+	var err error
+
+	for _, source := range sources {
+		folder, fetchErr := source.fetch(cacheDir)
+		if fetchErr != nil {
+			if err == nil {
+				err = fmt.Errorf("unable to fetch spec source [%s], none of its specs are loaded: %s", source.Name, fetchErr)
+			}
+			continue
+		}
+		folders = append(folders, folder)
+	}
+
+	specList, loadErr := loadFolders(folders)
+	if err == nil {
+		err = loadErr
+	}
+	return specList, err
+}
@@ -0,0 +1,150 @@
+package parser
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// A group a spec wants on the target, declared as a [GROUPS.<name>] section
+type Group struct {
+	Name   string `ini:"-"`
+	GID    string `ini:"gid"` // left to the system when empty
+	System bool   `ini:"system"`
+}
+
+// A user a spec wants on the target, declared as a [USERS.<name>] section
+type User struct {
+	Name           string   `ini:"-"`
+	UID            string   `ini:"uid"`   // left to the system when empty
+	Group          string   `ini:"group"` // the primary group, the group named after the user when empty
+	Groups         []string `ini:"groups,omitempty"`
+	Shell          string   `ini:"shell"`
+	Home           string   `ini:"home"` // /home/<name> when empty
+	System         bool     `ini:"system"`
+	AuthorizedKeys []string `ini:"authorized_keys,omitempty"` // public key files, relative to the spec
+	SpecRoot       string   `ini:"-"`
+}
+
+// Reads every [GROUPS.<name>] section of a spec
+func readGroups(cfg *ini.File) ([]Group, error) {
+	var groups []Group
+
+	for _, section := range cfg.Sections() {
+		if !strings.HasPrefix(section.Name(), "GROUPS.") {
+			continue
+		}
+
+		group := Group{Name: strings.TrimPrefix(section.Name(), "GROUPS.")}
+		if err := section.MapTo(&group); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// Reads every [USERS.<name>] section of a spec
+func readUsers(cfg *ini.File, specRoot string) ([]User, error) {
+	var users []User
+
+	for _, section := range cfg.Sections() {
+		if !strings.HasPrefix(section.Name(), "USERS.") {
+			continue
+		}
+
+		user := User{Name: strings.TrimPrefix(section.Name(), "USERS."), SpecRoot: specRoot}
+		if err := section.MapTo(&user); err != nil {
+			return nil, err
+		}
+		user.Groups = nonEmpty(trimAll(user.Groups))
+		user.AuthorizedKeys = nonEmpty(trimAll(user.AuthorizedKeys))
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+// Returns the home folder of the user
+func (u User) HomeDir() string {
+	if u.Home != "" {
+		return u.Home
+	}
+	return "/home/" + u.Name
+}
+
+// Reads the public key files of the user into the contents of an authorized_keys file
+func (u User) AuthorizedKeysFile() ([]byte, error) {
+	var contents []byte
+	for _, key := range u.AuthorizedKeys {
+		keyBytes, err := ioutil.ReadFile(filepath.Join(u.SpecRoot, key))
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, keyBytes...)
+		if len(keyBytes) > 0 && keyBytes[len(keyBytes)-1] != '\n' {
+			contents = append(contents, '\n')
+		}
+	}
+	return contents, nil
+}
+
+// Returns the groups of a spec and everything it requires, the declaration closest to the requested spec wins
+func (s *SpecList) Groups(specName string) []Group {
+	return mergeGroups(s.order(specName))
+}
+
+// Returns the users of a spec and everything it requires, the declaration closest to the requested spec wins
+func (s *SpecList) Users(specName string) []User {
+	return mergeUsers(s.order(specName))
+}
+
+// Merges the groups of specs in dependency order, later specs replace earlier declarations
+func mergeGroups(specs []*Spec) []Group {
+	var groups []Group
+	index := make(map[string]int)
+
+	for _, spec := range specs {
+		for _, group := range spec.Groups {
+			if i, ok := index[group.Name]; ok {
+				groups[i] = group
+				continue
+			}
+			index[group.Name] = len(groups)
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+// Merges the users of specs in dependency order, later specs replace earlier declarations
+func mergeUsers(specs []*Spec) []User {
+	var users []User
+	index := make(map[string]int)
+
+	for _, spec := range specs {
+		for _, user := range spec.Users {
+			if i, ok := index[user.Name]; ok {
+				users[i] = user
+				continue
+			}
+			index[user.Name] = len(users)
+			users = append(users, user)
+		}
+	}
+
+	return users
+}
+
+// Trims the spaces around each string
+func trimAll(items []string) []string {
+	trimmed := make([]string, 0, len(items))
+	for _, item := range items {
+		trimmed = append(trimmed, strings.TrimSpace(item))
+	}
+	return trimmed
+}
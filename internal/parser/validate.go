@@ -0,0 +1,405 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hil"
+	hilparser "github.com/hashicorp/hil/parser"
+	"github.com/praveensastry/cm/internal/packages"
+	"gopkg.in/ini.v1"
+)
+
+// A mistake found in a spec file, or in one of the files it transfers
+type Problem struct {
+	File    string
+	Line    int // 0 when the problem isn't on a particular line
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return p.File + ": " + p.Message
+	}
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+}
+
+// Sections whose keys are free form, rather than fields of a struct
+var freeformSections = map[string]bool{
+	"PERMISSIONS": true,
+	"VARS":        true,
+}
+
+// Sections that are repeated once per name, eg: [HANDLERS.reload_nginx]
+var namedSections = map[string]reflect.Type{
+	"HANDLERS": reflect.TypeOf(Handler{}),
+	"SERVICES": reflect.TypeOf(Service{}),
+	"GROUPS":   reflect.TypeOf(Group{}),
+	"USERS":    reflect.TypeOf(User{}),
+}
+
+// Checks spec files for the mistakes that loading them silently ignores. With no names, every spec
+// file that was found is checked, otherwise every version of the named specs.
+func (s *SpecList) Validate(specNames ...string) []Problem {
+	var problems []Problem
+
+	files := s.files
+	if len(specNames) > 0 {
+		files = nil
+		for _, name := range specNames {
+			if len(s.Versions[name]) == 0 {
+				problems = append(problems, Problem{File: name, Message: "unable to find a spec named [" + name + "]"})
+			}
+			for _, spec := range s.Versions[name] {
+				files = append(files, spec.SpecFile)
+			}
+		}
+	}
+
+	for _, file := range files {
+		problems = append(problems, s.validateFile(file)...)
+	}
+
+	return problems
+}
+
+// A section header or key of a spec file, and the line it is on
+type specLine struct {
+	Line    int
+	Section string // empty for the keys before the first section
+	Key     string // empty for a section header
+	Value   string
+}
+
+var sectionPattern = regexp.MustCompile(`^\[\s*([^\]]+?)\s*\]`)
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// Reads the section headers and keys of a spec file, so that problems can be given a line
+func readSpecLines(contents []byte) []specLine {
+	var lines []specLine
+	section := ""
+
+	for i, text := range strings.Split(string(contents), "\n") {
+		text = strings.TrimSpace(text)
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, ";") {
+			continue
+		}
+
+		if match := sectionPattern.FindStringSubmatch(text); match != nil {
+			section = match[1]
+			if section == ini.DefaultSection {
+				section = ""
+			}
+			lines = append(lines, specLine{Line: i + 1, Section: section})
+			continue
+		}
+
+		end := strings.IndexAny(text, "=:")
+		if end < 0 {
+			continue
+		}
+		lines = append(lines, specLine{
+			Line:    i + 1,
+			Section: section,
+			Key:     strings.TrimSpace(text[:end]),
+			Value:   strings.Trim(strings.TrimSpace(text[end+1:]), "\""),
+		})
+	}
+
+	return lines
+}
+
+// Returns the line a key is on, or 0 when it isn't set
+func lineOf(lines []specLine, section, key string) int {
+	for _, line := range lines {
+		if line.Section == section && line.Key == key {
+			return line.Line
+		}
+	}
+	return 0
+}
+
+// Returns the keys a section can have, and whether it is a known section at all.
+// Free form sections return no keys.
+func sectionKeys(section string) (map[string]bool, bool) {
+	if freeformSections[section] {
+		return nil, true
+	}
+
+	if dot := strings.Index(section, "."); dot > 0 {
+		if t, ok := namedSections[section[:dot]]; ok {
+			return iniKeys(t), true
+		}
+		return nil, false
+	}
+
+	t := reflect.TypeOf(Spec{})
+	keys := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		name := iniName(t.Field(i))
+		if name == "" {
+			continue
+		}
+		if t.Field(i).Type.Kind() == reflect.Struct {
+			if name == section {
+				return iniKeys(t.Field(i).Type), true
+			}
+		} else {
+			keys[name] = true
+		}
+	}
+
+	if section == "" {
+		return keys, true
+	}
+	return nil, false
+}
+
+// Returns the ini names of the fields of a struct
+func iniKeys(t reflect.Type) map[string]bool {
+	keys := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		if name := iniName(t.Field(i)); name != "" {
+			keys[name] = true
+		}
+	}
+	return keys
+}
+
+// Returns the ini name of a struct field, empty when it isn't read from the spec
+func iniName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("ini"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// Looks for a known key that a typo was probably meant to be, eg: apt-get for apt_get
+func suggestKey(keys map[string]bool, key string) string {
+	normalized := strings.ToLower(strings.Replace(key, "-", "_", -1))
+	for known := range keys {
+		if strings.ToLower(known) == normalized {
+			return known
+		}
+	}
+	return ""
+}
+
+// Checks a single spec file
+func (s *SpecList) validateFile(file string) []Problem {
+	var problems []Problem
+	problem := func(line int, format string, args ...interface{}) {
+		problems = append(problems, Problem{File: file, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		problem(0, "unable to read spec: %s", err)
+		return problems
+	}
+
+	cfg, err := ini.Load(contents)
+	if err != nil {
+		problem(0, "unable to parse spec: %s", err)
+		return problems
+	}
+
+	lines := readSpecLines(contents)
+
+	// Unknown sections and keys
+	for _, line := range lines {
+		keys, known := sectionKeys(line.Section)
+		switch {
+		case line.Key == "" && !known:
+			problem(line.Line, "unknown section [%s]", line.Section)
+		case line.Key == "" || !known || keys == nil || keys[line.Key]:
+			// fine, or already reported
+		case suggestKey(keys, line.Key) != "":
+			problem(line.Line, "unknown key [%s] in section [%s], did you mean [%s]?", line.Key, line.Section, suggestKey(keys, line.Key))
+		case line.Section == "":
+			problem(line.Line, "unknown key [%s]", line.Key)
+		default:
+			problem(line.Line, "unknown key [%s] in section [%s]", line.Key, line.Section)
+		}
+	}
+
+	if cfg.Section("").Key("NAME").String() == "" {
+		problem(lineOf(lines, "", "NAME"), "missing NAME, the spec will not be loaded")
+		return problems
+	}
+
+	// The rest needs the spec as it was loaded
+	var spec *Spec
+	for _, versions := range s.Versions {
+		for _, version := range versions {
+			if version.SpecFile == file {
+				spec = version
+			}
+		}
+	}
+	if spec == nil {
+		return problems
+	}
+
+	// Requirements
+	for _, entry := range spec.Requires {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || entry == "\"\"" {
+			continue
+		}
+		req, err := ParseRequirement(entry)
+		if err != nil {
+			problem(lineOf(lines, "", "REQUIRES"), "%s", err)
+			continue
+		}
+		if len(s.Versions[req.Name]) == 0 {
+			problem(lineOf(lines, "", "REQUIRES"), "requires spec [%s], which doesn't exist", req.Name)
+		} else if !s.choose(req.Name, []Requirement{req}).allowedBy([]Requirement{req}) {
+			problem(lineOf(lines, "", "REQUIRES"), "requires [%s], which no version of spec [%s] satisfies", req, req.Name)
+		}
+	}
+
+	// Handlers declared differently by the specs it requires, checked once for the version that gets picked
+	if s.root(spec.Name) == spec {
+		for _, conflict := range handlerConflicts(s.order(spec.Name)) {
+			line := lineOf(lines, "HANDLERS."+conflict.name, "")
+			if conflict.second != spec {
+				line = lineOf(lines, "", "REQUIRES")
+			}
+			problem(line, "%s", conflict)
+		}
+	}
+
+	// Package versions the package manager can't pin
+	for _, manager := range packages.Managers {
+		if err := manager.CheckPins(nonEmpty(spec.Packages.List(manager.Name()))); err != nil {
+			problem(lineOf(lines, "PACKAGES", manager.Name()), "%s", err)
+		}
+	}
+
+	// Commands and their guards
+	commands := []struct {
+		key     string
+		entries []string
+	}{
+		{"pre", spec.Commands.Pre},
+		{"post", spec.Commands.Post},
+	}
+	for _, c := range commands {
+		for _, entry := range nonEmpty(c.entries) {
+			if _, err := ParseCommand(entry); err != nil {
+				problem(lineOf(lines, "COMMANDS", c.key), "%s", err)
+			}
+		}
+	}
+
+	// Roots and the folders they are copied from
+	roots := []struct {
+		section, root, folder string
+		used                  bool
+	}{
+		{"CONFIGS", spec.Configs.DebianRoot, "configs", spec.Configs.DebianRoot != ""},
+		{"CONTENT", spec.Content.DebianRoot, "content", spec.Content.DebianRoot != "" && spec.Content.Source == "spec"},
+	}
+	for _, r := range roots {
+		line := lineOf(lines, r.section, "debian_root")
+		if r.root != "" && !strings.HasSuffix(r.root, "/") {
+			problem(line, "debian_root [%s] should end with a /, files are copied to the root followed by their path", r.root)
+		}
+		if r.used {
+			if info, err := os.Stat(filepath.Join(spec.SpecRoot, r.folder)); err != nil || !info.IsDir() {
+				problem(line, "debian_root is set, but the %s/ folder is missing next to the spec", r.folder)
+			}
+		}
+	}
+
+	// Content sources
+	switch spec.Content.Source {
+	case "", "spec":
+	case "git":
+		if spec.Content.Repo == "" {
+			problem(lineOf(lines, "CONTENT", "source"), "source is git, but there is no repo to fetch the content from")
+		}
+	case "archive":
+		if spec.Content.Archive == "" {
+			problem(lineOf(lines, "CONTENT", "source"), "source is archive, but there is no archive to unpack")
+		} else if _, err := os.Stat(spec.archivePath()); err != nil {
+			problem(lineOf(lines, "CONTENT", "archive"), "archive [%s] is missing", spec.Content.Archive)
+		}
+	case "http":
+		if spec.Content.URL == "" {
+			problem(lineOf(lines, "CONTENT", "source"), "source is http, but there is no url to download the content from")
+		}
+		if !sha256Pattern.MatchString(spec.Content.Sha256) {
+			problem(lineOf(lines, "CONTENT", "source"), "source is http, but sha256 isn't set to the 64 hex digit checksum of the download")
+		}
+	default:
+		problem(lineOf(lines, "CONTENT", "source"), "unknown content source [%s], should be spec, git, archive or http", spec.Content.Source)
+	}
+	if strings.HasPrefix(filepath.Clean(spec.Content.Subdir), "..") || filepath.IsAbs(spec.Content.Subdir) {
+		problem(lineOf(lines, "CONTENT", "subdir"), "subdir [%s] should be a folder inside the content", spec.Content.Subdir)
+	}
+
+	// Services
+	for _, service := range spec.Services {
+		section := "SERVICES." + service.Name
+		if service.State != "" && service.State != "running" && service.State != "stopped" {
+			problem(lineOf(lines, section, "state"), "state [%s] of service [%s] should be running or stopped", service.State, service.Name)
+		}
+		if _, err := strconv.ParseBool(service.Enabled); service.Enabled != "" && err != nil {
+			problem(lineOf(lines, section, "enabled"), "enabled [%s] of service [%s] should be true or false", service.Enabled, service.Name)
+		}
+		if service.OnChange != "" && service.OnChange != "reload" && service.OnChange != "restart" {
+			problem(lineOf(lines, section, "on_change"), "on_change [%s] of service [%s] should be reload or restart", service.OnChange, service.Name)
+		}
+	}
+
+	// Users and groups
+	for _, group := range spec.Groups {
+		if _, err := strconv.Atoi(group.GID); group.GID != "" && err != nil {
+			problem(lineOf(lines, "GROUPS."+group.Name, "gid"), "gid [%s] of group [%s] should be a number", group.GID, group.Name)
+		}
+	}
+	for _, user := range spec.Users {
+		section := "USERS." + user.Name
+		if _, err := strconv.Atoi(user.UID); user.UID != "" && err != nil {
+			problem(lineOf(lines, section, "uid"), "uid [%s] of user [%s] should be a number", user.UID, user.Name)
+		}
+		for _, key := range user.AuthorizedKeys {
+			if _, err := os.Stat(filepath.Join(spec.SpecRoot, key)); err != nil {
+				problem(lineOf(lines, section, "authorized_keys"), "authorized key [%s] of user [%s] is missing next to the spec", key, user.Name)
+			}
+		}
+	}
+
+	// Templates
+	if spec.Configs.DebianRoot != "" && !spec.Configs.SkipInterpolate {
+		for _, file := range *spec.debianFileTransfers() {
+			if !file.Interpolate {
+				continue
+			}
+			template, err := ioutil.ReadFile(file.Source)
+			if err != nil || bytes.IndexByte(template, 0) >= 0 {
+				continue
+			}
+			if _, err := hil.Parse(string(template)); err != nil {
+				if parseErr, ok := err.(*hilparser.ParseError); ok {
+					problems = append(problems, Problem{File: file.Source, Line: parseErr.Pos.Line, Message: "invalid template: " + parseErr.Message})
+				} else {
+					problems = append(problems, Problem{File: file.Source, Message: "invalid template: " + err.Error()})
+				}
+			}
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,150 @@
+package parser
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// A set of variables and where they were declared, eg: the [VARS] of a spec or a --var-file
+type VarLayer struct {
+	Source string
+	Values map[string]string
+}
+
+// The final value of a variable, the layer it came from, and the layers it overrides
+type Var struct {
+	Name       string
+	Value      string
+	Source     string
+	Overridden []string // sources of the values it replaced, lowest precedence first
+}
+
+// Reads the [VARS] section of a spec
+func readVars(cfg *ini.File) map[string]string {
+	section, err := cfg.GetSection("VARS")
+	if err != nil {
+		return nil
+	}
+
+	vars := make(map[string]string)
+	for _, key := range section.Keys() {
+		vars[key.Name()] = key.String()
+	}
+	return vars
+}
+
+// Returns the [VARS] defaults of a spec and everything it requires, in dependency order, so that a
+// spec overrides the defaults of the specs it requires
+func (s *SpecList) VarLayers(specName string) []VarLayer {
+	var layers []VarLayer
+	for _, spec := range s.order(specName) {
+		if len(spec.Vars) > 0 {
+			layers = append(layers, VarLayer{Source: "spec " + spec.Name, Values: spec.Vars})
+		}
+	}
+	return layers
+}
+
+// Works out the final value of each variable, later layers take precedence
+func ResolveVars(layers ...VarLayer) map[string]Var {
+	vars := make(map[string]Var)
+	for _, layer := range layers {
+		for name, value := range layer.Values {
+			v, ok := vars[name]
+			if ok {
+				v.Overridden = append(v.Overridden, v.Source)
+			}
+			v.Name, v.Value, v.Source = name, value, layer.Source
+			vars[name] = v
+		}
+	}
+	return vars
+}
+
+// Returns the final values of the variables, without where they came from
+func VarValues(vars map[string]Var) map[string]string {
+	values := make(map[string]string)
+	for name, v := range vars {
+		values[name] = v.Value
+	}
+	return values
+}
+
+// Matches a variable reference in a command, eg: ${var.http_port}, along with a $ escaping it
+var commandVar = regexp.MustCompile(`\$?\$\{var\.([A-Za-z0-9_.-]+)\}`)
+
+// Replaces the ${var.name} references in a command. Anything else, like ${HOME} or ${1}, is left
+// for the shell, and $${var.name} stands for a literal ${var.name}.
+func InterpolateCommand(command string, values map[string]string) (string, error) {
+	var missing string
+	result := commandVar.ReplaceAllStringFunc(command, func(ref string) string {
+		if strings.HasPrefix(ref, "$$") {
+			return ref[1:]
+		}
+		name := commandVar.FindStringSubmatch(ref)[1]
+		value, ok := values[name]
+		if !ok && missing == "" {
+			missing = name
+		}
+		return value
+	})
+	if missing != "" {
+		return "", fmt.Errorf("unknown variable var.%s in command [%s]", missing, command)
+	}
+	return result, nil
+}
+
+// Reads a file of name = value lines, as given to --var-file
+func ReadVarFile(file string) (VarLayer, error) {
+	layer := VarLayer{Source: "var file " + file, Values: make(map[string]string)}
+
+	if _, err := os.Stat(file); err != nil {
+		return layer, err
+	}
+	cfg, err := ini.Load(file)
+	if err != nil {
+		return layer, err
+	}
+	for _, key := range cfg.Section("").Keys() {
+		layer.Values[key.Name()] = key.String()
+	}
+
+	return layer, nil
+}
+
+// Reads name=value pairs, as given to --var
+func ParseVarFlags(flags []string) (VarLayer, error) {
+	layer := VarLayer{Source: "--var", Values: make(map[string]string)}
+
+	for _, flag := range flags {
+		eq := strings.Index(flag, "=")
+		if eq <= 0 {
+			return layer, fmt.Errorf("--var [%s] should look like name=value", flag)
+		}
+		layer.Values[strings.TrimSpace(flag[:eq])] = flag[eq+1:]
+	}
+
+	return layer, nil
+}
+
+// Prints the final value of each variable in a table, along with where it came from
+func PrintVars(vars map[string]Var) {
+	var names []string
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rows [][]string
+	for _, name := range names {
+		v := vars[name]
+		rows = append(rows, []string{"${var." + name + "}", v.Value, v.Source, strings.Join(v.Overridden, ", ")})
+	}
+
+	printTable([]string{"Variable", "Value", "From", "Overrides"}, rows)
+}
@@ -0,0 +1,192 @@
+package parser
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A single entry of REQUIRES, such as `nginx`, `nginx >= 2` or `php ~> 7.2`
+type Requirement struct {
+	Name       string
+	Operator   string // one of =, !=, >, >=, <, <= and ~>, empty when any version will do
+	Version    string
+	RequiredBy string // the spec and version that listed the requirement
+}
+
+var requirementPattern = regexp.MustCompile(`^([^\s<>=!~]+)\s*(?:(=|!=|>=|<=|>|<|~>)\s*(\S+))?$`)
+
+// Parses a single entry of REQUIRES
+func ParseRequirement(entry string) (Requirement, error) {
+	match := requirementPattern.FindStringSubmatch(strings.TrimSpace(entry))
+	if match == nil {
+		return Requirement{}, fmt.Errorf("invalid requirement [%s], expected a spec name optionally followed by =, !=, >, >=, <, <= or ~> and a version", strings.TrimSpace(entry))
+	}
+
+	return Requirement{Name: match[1], Operator: match[2], Version: match[3]}, nil
+}
+
+func (r Requirement) String() string {
+	if r.Operator == "" {
+		return r.Name
+	}
+	return r.Name + " " + r.Operator + " " + r.Version
+}
+
+// Checks whether a version meets the requirement. The pessimistic operator ~> allows the last
+// given part of the version to go up, so ~> 7 allows 7.x and ~> 7.2 allows 7.2 up to, but not including, 8.
+func (r Requirement) Allows(version string) bool {
+	cmp := compareVersions(version, r.Version)
+
+	switch r.Operator {
+	case "":
+		return true
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "~>":
+		parts := strings.Split(r.Version, ".")
+		prefix := len(parts) - 1
+		if prefix < 1 {
+			prefix = 1
+		}
+		have := strings.Split(version, ".")
+		if len(have) < prefix {
+			return false
+		}
+		return cmp >= 0 && compareVersions(strings.Join(have[:prefix], "."), strings.Join(parts[:prefix], ".")) == 0
+	}
+
+	return false
+}
+
+// Returned when no version of a spec meets all of the requirements placed on it
+type ConflictError struct {
+	Name         string
+	Requirements []Requirement
+	Available    []string // every known version, highest first
+}
+
+func (e *ConflictError) Error() string {
+	var reqs []string
+	for _, req := range e.Requirements {
+		reqs = append(reqs, req.String()+" (required by "+req.RequiredBy+")")
+	}
+	return fmt.Sprintf("no version of spec [%s] satisfies %s, available versions: %s", e.Name, strings.Join(reqs, " and "), strings.Join(e.Available, ", "))
+}
+
+// Picks the highest version of a spec that meets every requirement, or the highest version
+// when none does. Returns nil when there is no version of the spec at all.
+func (s *SpecList) choose(name string, reqs []Requirement) *Spec {
+	for _, spec := range s.Versions[name] {
+		if spec.allowedBy(reqs) {
+			return spec
+		}
+	}
+
+	return s.Specs[name]
+}
+
+// Checks whether a spec meets every one of the requirements
+func (spec *Spec) allowedBy(reqs []Requirement) bool {
+	for _, req := range reqs {
+		if !req.Allows(spec.version()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns an error for the first spec in an order that doesn't meet the requirements placed on it
+func (s *SpecList) checkConstraints(order []*Spec, constraints map[string][]Requirement) error {
+	for _, spec := range order {
+		if spec.allowedBy(constraints[spec.Name]) {
+			continue
+		}
+
+		err := &ConflictError{Name: spec.Name}
+		for _, req := range constraints[spec.Name] {
+			if req.Operator != "" {
+				err.Requirements = append(err.Requirements, req)
+			}
+		}
+		for _, version := range s.Versions[spec.Name] {
+			err.Available = append(err.Available, version.version())
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Adds a spec to the list, next to any other versions of it. A spec with the same name and version
+// as one already in the list replaces it.
+func (s *SpecList) add(spec *Spec) {
+	versions := s.Versions[spec.Name]
+	replaced := false
+	for i, existing := range versions {
+		if compareVersions(existing.version(), spec.version()) == 0 {
+			s.Shadowed = append(s.Shadowed, Shadowed{Spec: existing, By: spec})
+			versions[i] = spec
+			replaced = true
+		}
+	}
+	if !replaced {
+		versions = append(versions, spec)
+	}
+
+	// Highest version first
+	sort.SliceStable(versions, func(i, j int) bool {
+		return compareVersions(versions[i].version(), versions[j].version()) > 0
+	})
+
+	s.Versions[spec.Name] = versions
+	s.Specs[spec.Name] = versions[0]
+}
+
+// Returns the version of a spec, specs without one count as version 0
+func (spec *Spec) version() string {
+	if strings.TrimSpace(spec.Version) == "" {
+		return "0"
+	}
+	return strings.TrimSpace(spec.Version)
+}
+
+// Compares two dotted versions part by part, numerically where both parts are numbers.
+// Missing parts count as 0, so 2 and 2.0 are the same version.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		x, y := "0", "0"
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+
+		xn, xerr := strconv.Atoi(x)
+		yn, yerr := strconv.Atoi(y)
+		switch {
+		case xerr == nil && yerr == nil && xn != yn:
+			if xn < yn {
+				return -1
+			}
+			return 1
+		case (xerr != nil || yerr != nil) && x != y:
+			return strings.Compare(x, y)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,170 @@
+package servers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"github.com/praveensastry/cm/terminal"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Holds the private keys and ssh-agent shared by every job in a configure run, so that
+// each identity file is only read (and its passphrase only asked for) once.
+type keyring struct {
+	signers map[string]ssh.Signer
+	agent   agent.Agent
+	conn    net.Conn
+}
+
+// Creates a new keyring, connecting to the running ssh-agent if SSH_AUTH_SOCK is set
+func newKeyring() *keyring {
+	keys := new(keyring)
+	keys.signers = make(map[string]ssh.Signer)
+
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return keys
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		terminal.Information(fmt.Sprintf("Unable to connect to ssh-agent at [%s], skipping it", socket))
+		return keys
+	}
+
+	keys.conn = conn
+	keys.agent = agent.NewClient(conn)
+
+	return keys
+}
+
+// Closes the connection to the ssh-agent, if we have one
+func (k *keyring) Close() error {
+	if k.conn != nil {
+		return k.conn.Close()
+	}
+	return nil
+}
+
+// Loads every identity file of the given server into the keyring, prompting for passphrases as needed.
+// Identity files from ~/.ssh/config that don't exist are skipped, like ssh does, eg: a default
+// ~/.ssh/id_rsa under Host *, but those set in the inventory have to be there.
+func (k *keyring) load(server Server) error {
+	for _, identityFile := range server.identityFiles() {
+		identityFile = expandHome(strings.TrimSpace(identityFile))
+		if identityFile == "" {
+			continue
+		}
+		if _, ok := k.signers[identityFile]; ok {
+			continue
+		}
+
+		keyBytes, err := ioutil.ReadFile(identityFile)
+		if os.IsNotExist(err) && !listsIdentityFile(server.IdentityFile, identityFile) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read identity file [%s]: %s", identityFile, err)
+		}
+
+		signer, err := ssh.ParsePrivateKey(keyBytes)
+		if _, ok := err.(*ssh.PassphraseMissingError); ok {
+			passphrase := terminal.PromptPassword(fmt.Sprintf("Please enter the passphrase for identity file [%s]:", identityFile))
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(keyBytes, []byte(passphrase))
+		}
+		if err != nil {
+			return fmt.Errorf("unable to parse identity file [%s]: %s", identityFile, err)
+		}
+
+		k.signers[identityFile] = signer
+	}
+
+	return nil
+}
+
+// Returns the ssh auth methods for a given server. Identity files are offered before any
+// keys held by the ssh-agent, and password auth is only used when the server asks for it.
+func (k *keyring) authMethods(server Server) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+
+	var signers []ssh.Signer
+	for _, identityFile := range server.identityFiles() {
+		if signer, ok := k.signers[expandHome(strings.TrimSpace(identityFile))]; ok {
+			signers = append(signers, signer)
+		}
+	}
+
+	if len(signers) > 0 || k.agent != nil {
+		// The ssh client only tries each auth method once, so all keys are offered through a single callback
+		methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			if k.agent == nil {
+				return signers, nil
+			}
+			agentSigners, err := k.agent.Signers()
+			if err != nil {
+				return signers, nil
+			}
+			return append(signers, agentSigners...), nil
+		}))
+	}
+
+	if server.PassAuth {
+		methods = append(methods, ssh.Password(server.Password))
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no identity file, ssh-agent or password available for [%s]", server.Name)
+	}
+
+	return methods, nil
+}
+
+// Returns the identity files of the inventory followed by those of ~/.ssh/config
+func (s *Server) identityFiles() []string {
+	return append(append([]string{}, s.IdentityFile...), s.ConfigIdentityFile...)
+}
+
+// Checks whether a list of identity files holds a given file, once expanded
+func listsIdentityFile(files []string, file string) bool {
+	for _, f := range files {
+		if expandHome(strings.TrimSpace(f)) == file {
+			return true
+		}
+	}
+	return false
+}
+
+// Assembles the ssh client config used to connect to a given server
+func clientConfig(server Server, keys *keyring, hostKeys *hostKeys) (*ssh.ClientConfig, error) {
+	auth, err := keys.authMethods(server)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:              server.Username,
+		Auth:              auth,
+		HostKeyCallback:   hostKeys.callback(server),
+		HostKeyAlgorithms: hostKeys.algorithms(server.address()),
+	}, nil
+}
+
+// Expands a leading ~/ into the current users home directory
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	currentUser, err := user.Current()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(currentUser.HomeDir, path[2:])
+}
@@ -0,0 +1,47 @@
+package servers
+
+import "golang.org/x/crypto/ssh"
+
+// Builds the host key callback over the given known hosts files, answering any prompt with answer
+// and counting the prompts, so the trust decisions can be tested without a terminal
+func HostKeyCallback(knownHosts, cmKnownHosts string, strict, answer bool, prompts *int) ssh.HostKeyCallback {
+	h := &hostKeys{
+		knownHostsFiles: []string{knownHosts},
+		cmKnownHosts:    cmKnownHosts,
+		strict:          strict,
+		prompt: func(string) bool {
+			*prompts++
+			return answer
+		},
+	}
+	return h.callback(Server{Name: "test"})
+}
+
+// Returns the host key algorithms a server is asked for, given a known hosts file
+func HostKeyAlgorithms(knownHosts, address string) []string {
+	h := &hostKeys{knownHostsFiles: []string{knownHosts}}
+	return h.algorithms(address)
+}
+
+// Applies an ssh config file to a server and builds its jump hosts, the way RemoteConfigure does
+func ApplySSHConfig(file string, server Server) (Server, []Server, error) {
+	config, err := readSSHConfig(file)
+	if err != nil {
+		return server, nil, err
+	}
+
+	server.applySSHConfig(config)
+	jumps, err := jumpServers(server, config)
+	return server, jumps, err
+}
+
+// Loads the identity files of a server into a new keyring
+func LoadIdentityFiles(server Server) error {
+	keys := &keyring{signers: make(map[string]ssh.Signer)}
+	return keys.load(server)
+}
+
+// The host:port a server is dialled on
+func (s *Server) Address() string {
+	return s.address()
+}
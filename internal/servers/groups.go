@@ -0,0 +1,167 @@
+package servers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/praveensastry/cm/internal/parser"
+)
+
+// A named group of servers in the inventory, eg: web or prod. Groups can hold other groups, and
+// give their members a default spec and variables.
+type Group struct {
+	Name   string            `ini:"-"` // considered GROUP.<name> Sections in config file
+	Hosts  []string          `ini:"Hosts,omitempty"`
+	Groups []string          `ini:"Groups,omitempty"` // Nested groups, whose hosts are members too
+	Spec   string            `ini:"Spec,omitempty"`   // Used by member hosts that don't set a Spec
+	Vars   map[string]string `ini:"-"`                // Stored as var.<name> keys, overridden by the vars of nested groups and hosts
+}
+
+// Slice of inventory groups
+type Groups []Group
+
+// Works out the groups each server belongs to, and fills in the spec and vars they give it.
+// A server gets the spec of the closest group that sets one, and the vars of every group it is in,
+// where nested groups override the groups holding them.
+func ResolveGroups(servers Servers, groups Groups) error {
+	byName := make(map[string]*Group)
+	for i := range groups {
+		byName[groups[i].Name] = &groups[i]
+	}
+	hosts := make(map[string]*Server)
+	for i := range servers {
+		hosts[servers[i].Name] = &servers[i]
+	}
+
+	for i := range servers {
+		if servers[i].SpecFrom != "" {
+			servers[i].Spec = ""
+		}
+		servers[i].Groups, servers[i].SpecFrom, servers[i].groupVars = nil, "", nil
+	}
+
+	// Walk down from every group, so each server learns the path of groups leading to it
+	var visit func(group *Group, path []string) error
+	visit = func(group *Group, path []string) error {
+		for _, name := range path {
+			if name == group.Name {
+				return fmt.Errorf("inventory groups form a cycle: %s -> %s", strings.Join(path, " -> "), group.Name)
+			}
+		}
+		path = append(path, group.Name)
+
+		for _, hostName := range group.Hosts {
+			host, ok := hosts[hostName]
+			if !ok {
+				return fmt.Errorf("unable to find host [%s], listed in group [%s]", hostName, group.Name)
+			}
+			host.addGroupPath(path)
+		}
+		for _, childName := range group.Groups {
+			child, ok := byName[childName]
+			if !ok {
+				return fmt.Errorf("unable to find group [%s], listed in group [%s]", childName, group.Name)
+			}
+			if err := visit(child, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for i := range groups {
+		if err := visit(&groups[i], nil); err != nil {
+			return err
+		}
+	}
+
+	for i := range servers {
+		servers[i].applyGroups(byName)
+	}
+
+	return nil
+}
+
+// Records the groups on a path down to the server, each one once, along with how many groups
+// away from the server it is
+func (s *Server) addGroupPath(path []string) {
+	if s.groupDistance == nil {
+		s.groupDistance = make(map[string]int)
+	}
+	for i, name := range path {
+		distance := len(path) - 1 - i
+		if known, ok := s.groupDistance[name]; ok {
+			// The shortest path decides how close a group is
+			if distance < known {
+				s.groupDistance[name] = distance
+			}
+			continue
+		}
+		s.groupDistance[name] = distance
+		s.Groups = append(s.Groups, name)
+	}
+}
+
+// Fills in the default spec and the group vars of a server, once its groups are known
+func (s *Server) applyGroups(byName map[string]*Group) {
+	// Farthest groups first, so closer groups override them, in inventory order otherwise
+	ordered := make([]string, len(s.Groups))
+	copy(ordered, s.Groups)
+	for i := 1; i < len(ordered); i++ {
+		for j := i; j > 0 && s.groupDistance[ordered[j]] > s.groupDistance[ordered[j-1]]; j-- {
+			ordered[j], ordered[j-1] = ordered[j-1], ordered[j]
+		}
+	}
+
+	for _, name := range ordered {
+		group := byName[name]
+		if len(group.Vars) > 0 {
+			s.groupVars = append(s.groupVars, parser.VarLayer{Source: "group " + name, Values: group.Vars})
+		}
+		if group.Spec != "" && (s.Spec == "" || s.SpecFrom != "") {
+			s.Spec, s.SpecFrom = group.Spec, name
+		}
+	}
+	s.groupDistance = nil
+}
+
+// Checks whether the server is a member of a group, directly or through a nested group
+func (s *Server) InGroup(name string) bool {
+	for _, group := range s.Groups {
+		if group == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Takes a host out of every group that lists it, eg: when it is deleted from the inventory
+func (g Groups) RemoveHost(name string) {
+	for i := range g {
+		var hosts []string
+		for _, host := range g[i].Hosts {
+			if host != name {
+				hosts = append(hosts, host)
+			}
+		}
+		g[i].Hosts = hosts
+	}
+}
+
+// Prints each group with its hosts, nested groups and spec in a table
+func (g Groups) PrintGroupInfo() {
+	collumns := []string{"Group", "Hosts", "Groups", "Spec", "Vars"}
+	var rows [][]string
+
+	for _, group := range g {
+		rows = append(rows, []string{
+			group.Name,
+			strings.Join(group.Hosts, ", "),
+			strings.Join(group.Groups, ", "),
+			group.Spec,
+			fmt.Sprint(len(group.Vars)),
+		})
+	}
+
+	printTable(collumns, rows)
+}
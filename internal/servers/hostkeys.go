@@ -0,0 +1,140 @@
+package servers
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/praveensastry/cm/terminal"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Verifies remote host keys against the users known_hosts file and cm's own known hosts file.
+// Keys that are trusted on first use get appended to cm's file, never to ~/.ssh/known_hosts.
+type hostKeys struct {
+	knownHostsFiles []string
+	cmKnownHosts    string
+	strict          bool
+	prompt          func(question string) bool // asks whether to trust an unknown key
+	mutex           sync.Mutex                 // jobs verify in parallel, but only one can prompt or write at a time
+}
+
+// Assembles a new hostKeys verifier, strict verifiers fail on unknown keys instead of prompting
+func newHostKeys(strict bool) *hostKeys {
+	currentUser, _ := user.Current()
+
+	return &hostKeys{
+		knownHostsFiles: []string{currentUser.HomeDir + "/.ssh/known_hosts"},
+		cmKnownHosts:    currentUser.HomeDir + "/.cmknownhosts",
+		strict:          strict,
+		prompt:          terminal.PromptBool,
+	}
+}
+
+// Returns the HostKeyCallback for a given server
+func (h *hostKeys) callback(server Server) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+
+		// Re-read the files every time, so keys trusted by a parallel job are picked up
+		check, err := knownhosts.New(h.existingFiles()...)
+		if err != nil {
+			return fmt.Errorf("unable to read known hosts files: %s", err)
+		}
+
+		err = check(hostname, remote, key)
+		keyErr, ok := err.(*knownhosts.KeyError)
+		if !ok {
+			return err
+		}
+
+		// A known host has to present one of its known keys, like OpenSSH, otherwise a man in the
+		// middle could offer a key of another type and have it trusted as if it was the first use
+		var knownTypes []string
+		for _, known := range keyErr.Want {
+			if known.Key.Type() == key.Type() {
+				return fmt.Errorf("the host key of [%s] (%s) has CHANGED since it was last trusted, it was [%s] in %s:%d and is now [%s]. Refusing to connect",
+					server.Name, hostname, ssh.FingerprintSHA256(known.Key), known.Filename, known.Line, ssh.FingerprintSHA256(key))
+			}
+			knownTypes = append(knownTypes, known.Key.Type())
+		}
+		if len(knownTypes) > 0 {
+			return fmt.Errorf("[%s] (%s) presented a %s host key [%s], but only its %s keys are known. Refusing to connect",
+				server.Name, hostname, key.Type(), ssh.FingerprintSHA256(key), strings.Join(knownTypes, ", "))
+		}
+
+		if h.strict {
+			return fmt.Errorf("the %s host key of [%s] (%s) is unknown and strict host key checking is enabled", key.Type(), server.Name, hostname)
+		}
+
+		trust := h.prompt(fmt.Sprintf("The authenticity of [%s] (%s) can't be established. %s key fingerprint is %s. Do you want to trust it?",
+			server.Name, hostname, key.Type(), ssh.FingerprintSHA256(key)))
+		if !trust {
+			return fmt.Errorf("the host key of [%s] (%s) was not trusted", server.Name, hostname)
+		}
+
+		return h.trust(hostname, key)
+	}
+}
+
+// Returns the types of the keys known for a host:port address, so that the host is asked for one
+// of those rather than a key of a type that isn't known. Nil when the host isn't known at all.
+func (h *hostKeys) algorithms(address string) []string {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	check, err := knownhosts.New(h.existingFiles()...)
+	if err != nil {
+		return nil
+	}
+
+	// knownhosts can't list the keys of a host, but it does on a mismatch, which a probe key always is
+	err = check(address, &net.TCPAddr{IP: net.IPv4zero}, probeKey{})
+	keyErr, ok := err.(*knownhosts.KeyError)
+	if !ok {
+		return nil
+	}
+
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		algorithms = append(algorithms, known.Key.Type())
+	}
+	sort.Strings(algorithms)
+	return algorithms
+}
+
+// A public key that matches no known key, used to list the known keys of a host
+type probeKey struct{}
+
+func (probeKey) Type() string                                 { return "cm-probe" }
+func (probeKey) Marshal() []byte                              { return []byte("cm-probe") }
+func (probeKey) Verify(data []byte, sig *ssh.Signature) error { return fmt.Errorf("probe key") }
+
+// Appends a host key to cm's known hosts file
+func (h *hostKeys) trust(hostname string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(h.cmKnownHosts, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key) + "\n")
+	return err
+}
+
+// Returns the known hosts files that exist, knownhosts.New fails on missing files
+func (h *hostKeys) existingFiles() []string {
+	var files []string
+	for _, file := range append(h.knownHostsFiles, h.cmKnownHosts) {
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	return files
+}
@@ -0,0 +1,117 @@
+package servers_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/praveensastry/cm/internal/servers"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestHostKeyCallback(t *testing.T) {
+	newEd25519 := func() ssh.PublicKey {
+		public, _, err := ed25519.GenerateKey(rand.Reader)
+		assert.NoError(t, err)
+		key, err := ssh.NewPublicKey(public)
+		assert.NoError(t, err)
+		return key
+	}
+	newECDSA := func() ssh.PublicKey {
+		private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+		key, err := ssh.NewPublicKey(&private.PublicKey)
+		assert.NoError(t, err)
+		return key
+	}
+
+	trusted := newEd25519()
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+	cases := []struct {
+		name    string
+		known   bool // whether the trusted key is in known_hosts
+		key     ssh.PublicKey
+		strict  bool
+		answer  bool
+		prompts int
+		err     string
+	}{
+		{name: "unknown host, trusted", key: trusted, answer: true, prompts: 1},
+		{name: "unknown host, declined", key: trusted, prompts: 1, err: "was not trusted"},
+		{name: "unknown host, strict", key: trusted, strict: true, err: "is unknown and strict host key checking is enabled"},
+		{name: "matching key", known: true, key: trusted, strict: true},
+		{name: "changed key", known: true, key: newEd25519(), answer: true, err: "has CHANGED since it was last trusted"},
+		{name: "other algorithm", known: true, key: newECDSA(), answer: true, err: "but only its ssh-ed25519 keys are known. Refusing to connect"},
+		{name: "other algorithm, strict", known: true, key: newECDSA(), strict: true, err: "but only its ssh-ed25519 keys are known. Refusing to connect"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "cm-hostkeys")
+			assert.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			knownHosts := filepath.Join(dir, "known_hosts")
+			contents := ""
+			if c.known {
+				contents = knownhosts.Line([]string{"example.com"}, trusted) + "\n"
+			}
+			assert.NoError(t, ioutil.WriteFile(knownHosts, []byte(contents), 0600))
+			cmKnownHosts := filepath.Join(dir, "cmknownhosts")
+
+			prompts := 0
+			callback := servers.HostKeyCallback(knownHosts, cmKnownHosts, c.strict, c.answer, &prompts)
+			err = callback("example.com:22", remote, c.key)
+
+			assert.Equal(t, c.prompts, prompts)
+			if c.err != "" {
+				assert.Error(t, err)
+				if err != nil {
+					assert.Contains(t, err.Error(), c.err)
+				}
+				return
+			}
+			assert.NoError(t, err)
+
+			// A trusted key is remembered, and accepted without asking again
+			err = servers.HostKeyCallback(knownHosts, cmKnownHosts, true, false, &prompts)("example.com:22", remote, c.key)
+			assert.NoError(t, err)
+			assert.Equal(t, c.prompts, prompts)
+		})
+	}
+}
+
+func TestHostKeyAlgorithms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-hostkeys")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	edKey, err := ssh.NewPublicKey(public)
+	assert.NoError(t, err)
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	ecKey, err := ssh.NewPublicKey(&private.PublicKey)
+	assert.NoError(t, err)
+
+	knownHosts := filepath.Join(dir, "known_hosts")
+	contents := knownhosts.Line([]string{"example.com"}, edKey) + "\n" +
+		knownhosts.Line([]string{"example.com", "[example.com]:2222"}, ecKey) + "\n"
+	assert.NoError(t, ioutil.WriteFile(knownHosts, []byte(contents), 0600))
+
+	assert.Equal(t, []string{"ecdsa-sha2-nistp256", "ssh-ed25519"}, servers.HostKeyAlgorithms(knownHosts, "example.com:22"))
+	assert.Equal(t, []string{"ecdsa-sha2-nistp256"}, servers.HostKeyAlgorithms(knownHosts, "example.com:2222"))
+
+	// Unknown hosts are asked for any key
+	assert.Nil(t, servers.HostKeyAlgorithms(knownHosts, "other.example.com:22"))
+}
@@ -1,7 +1,6 @@
 package servers
 
 import (
-	"bytes"
 	"fmt"
 	"net"
 	"os"
@@ -10,37 +9,78 @@ import (
 	"time"
 
 	"github.com/olekukonko/tablewriter"
-	"github.com/pkg/sftp"
+	"github.com/praveensastry/cm/internal/content"
+	"github.com/praveensastry/cm/internal/engine"
 	"github.com/praveensastry/cm/internal/parser"
+	"github.com/praveensastry/cm/internal/transport"
 	"github.com/praveensastry/cm/terminal"
 	"golang.org/x/crypto/ssh"
 )
 
 // Represents a single remote server
 type Server struct {
-	Name     string `ini:"-"` // considered Sections in config file
-	Host     string
-	Username string
-	Spec     string
-	PassAuth bool
-	Password string `ini:"-"` // Not stored in config, just where it gets temporarily stored when we ask for it.
+	Name               string `ini:"-"` // considered Sections in config file
+	Host               string
+	Username           string
+	Spec               string
+	Port               int `ini:"Port,omitempty"` // Defaults to 22, or the Port from ~/.ssh/config
+	PassAuth           bool
+	IdentityFile       []string          `ini:"IdentityFile,omitempty"` // Private keys to offer, in addition to any held by the ssh-agent
+	JumpHost           []string          `ini:"JumpHost,omitempty"`     // Bastions to hop through in order, as [user@]host[:port] or ~/.ssh/config aliases
+	Class              string            `ini:"Class,omitempty"`        // Interpolated into spec configs as ${var.class}
+	Sequence           string            `ini:"Sequence,omitempty"`     // Interpolated into spec configs as ${var.sequence}
+	Locale             string            `ini:"Locale,omitempty"`       // Interpolated into spec configs as ${var.locale}
+	Password           string            `ini:"-"`                      // Not stored in config, just where it gets temporarily stored when we ask for it.
+	Vars               map[string]string `ini:"-"`                      // Stored as var.<name> keys, interpolated as ${var.<name>} over the spec defaults
+	ConfigIdentityFile []string          `ini:"-"`                      // Identity files from ~/.ssh/config, skipped when missing like ssh does
+	Groups             []string          `ini:"-"`                      // Every group the server is in, directly or through nested groups, see ResolveGroups
+	SpecFrom           string            `ini:"-"`                      // The group the Spec comes from, empty when the server sets it
+
+	groupVars     []parser.VarLayer // the vars of its groups, farthest group first
+	groupDistance map[string]int    // only used while resolving groups
 }
 
 // Slice of remote servers with attached methods
 type Servers []Server
 
+// Options for a remote configuration run, usually set from cli flags
+type ConfigureOptions struct {
+	StrictHostKeys bool              // Fail on unknown host keys instead of prompting to trust them
+	Plan           bool              // Only show what would change on each server, without changing anything
+	ConfirmEach    bool              // Ask before overwriting each existing file that would change
+	Flat           bool              // Run each step across the whole REQUIRES tree, instead of one spec at a time
+	Lock           *parser.Lock      // When set, refuse to configure servers whose specs no longer match it
+	IgnoreLock     bool              // Only warn about specs that don't match the lock
+	Offline        bool              // Use cached clones of specs and content that couldn't be fetched
+	Vars           []parser.VarLayer // Variables from --var-file and --var, which override the host vars
+}
+
 // Remote Job
 type RemoteJob struct {
-	net.Conn
-	Server    Server
-	SSHConf   *ssh.ClientConfig
-	Timeout   time.Duration
-	Responses chan string
-	Errors    chan error
-	WaitGroup *sync.WaitGroup
-	SpecList  *parser.SpecList
-	SpecName  string
-	Client    *ssh.Client
+	Server      Server
+	SSHConf     *ssh.ClientConfig
+	Timeout     time.Duration // How long connecting to the server may take
+	Responses   chan string
+	Errors      chan error
+	WaitGroup   *sync.WaitGroup
+	SpecList    *parser.SpecList
+	SpecName    string
+	Client      *ssh.Client
+	Jumps       []jumpHost
+	PlanOnly    bool
+	Plan        *engine.Plan // Set after a PlanOnly run that reached the server
+	ConfirmEach bool
+	Flat        bool
+	Offline     bool
+	Vars        []parser.VarLayer // Overrides the host vars
+	Summary     engine.Summary
+	Status      string // unreachable, failed or ok once the job is done
+}
+
+// A bastion hop on the way to a remote server
+type jumpHost struct {
+	Server  Server
+	SSHConf *ssh.ClientConfig
 }
 
 // Assembles a new Server struct
@@ -58,53 +98,67 @@ func New(name, host, username, spec string, passAuth bool) *Server {
 
 }
 
+// Returns the vars of the groups of the server followed by its own, which override the [VARS] of its specs
+func (s *Server) VarLayers() []parser.VarLayer {
+	layers := append([]parser.VarLayer{}, s.groupVars...)
+	return append(layers, parser.VarLayer{Source: "host " + s.Name, Values: s.Vars})
+}
+
 // Prints a single server config data in a table
 func (s *Server) PrintServerInfo() {
 
-	collumns := []string{"Name", "Host", "Username", "Spec", "Password Auth?"}
+	collumns := []string{"Name", "Host", "Username", "Spec", "Password Auth?", "Identity File", "Jump Host"}
 	var rows [][]string
 
 	rows = append(rows, []string{
 		s.Name,
-		s.Host,
+		s.displayHost(),
 		s.Username,
 		s.Spec,
 		fmt.Sprintf("%t", s.PassAuth),
+		strings.Join(s.IdentityFile, ", "),
+		strings.Join(s.JumpHost, " -> "),
 	})
 
 	printTable(collumns, rows)
 }
 
-// Read function with timeout
-func (r *RemoteJob) Read(b []byte) (int, error) {
-	err := r.Conn.SetReadDeadline(time.Now().Add(r.Timeout))
-	if err != nil {
-		return 0, err
-	}
-	return r.Conn.Read(b)
-}
-
-// Write function with timeout
-func (r *RemoteJob) Write(b []byte) (int, error) {
-	err := r.Conn.SetWriteDeadline(time.Now().Add(r.Timeout))
-	if err != nil {
-		return 0, err
-	}
-	return r.Conn.Write(b)
-}
-
 // Run Remote Configuration on a target spec group
-func (s Servers) RemoteConfigure(search string, specList *parser.SpecList) {
+func (s Servers) RemoteConfigure(search string, specList *parser.SpecList, opts ConfigureOptions) {
 
 	// Get our list of targets
 	targetGroup := s.getTargetGroup(search)
 
-	configure := terminal.PromptBool("Do you want to configure these servers?")
+	// Make sure the specs are the ones that were locked
+	if opts.Lock != nil && !checkLock(targetGroup, specList, opts) {
+		return
+	}
+
+	// Plans don't change anything, so there is nothing to confirm
+	if !opts.Plan {
+		configure := terminal.PromptBool("Do you want to configure these servers?")
 
-	if !configure {
-		terminal.Information("Okay, maybe next time..")
+		if !configure {
+			terminal.Information("Okay, maybe next time..")
+			return
+		}
+	}
+
+	// Let ~/.ssh/config fill in host aliases, users, ports, keys and jump hosts
+	sshConfig, err := readUserSSHConfig()
+	if err != nil {
+		terminal.ShowErrorMessage("Unable to read ~/.ssh/config!", err.Error())
 		return
 	}
+	jumps := make([][]Server, len(targetGroup))
+	for i := range targetGroup {
+		targetGroup[i].applySSHConfig(sshConfig)
+		jumps[i], err = jumpServers(targetGroup[i], sshConfig)
+		if err != nil {
+			terminal.ShowErrorMessage("Unable to find the jump hosts of ["+targetGroup[i].Name+"]!", err.Error())
+			return
+		}
+	}
 
 	// Get passwords for hosts that need them
 	for i, server := range targetGroup {
@@ -113,6 +167,20 @@ func (s Servers) RemoteConfigure(search string, specList *parser.SpecList) {
 		}
 	}
 
+	// Load the identity files up front, so each passphrase is only asked for once
+	keys := newKeyring()
+	defer keys.Close()
+	for i, server := range targetGroup {
+		for _, target := range append(jumps[i], server) {
+			if err := keys.load(target); err != nil {
+				terminal.ShowErrorMessage("Unable to load SSH key!", err.Error())
+				return
+			}
+		}
+	}
+
+	hostKeys := newHostKeys(opts.StrictHostKeys)
+
 	terminal.Information("Initiating config manager...")
 
 	responses := make(chan string, 10)
@@ -122,33 +190,51 @@ func (s Servers) RemoteConfigure(search string, specList *parser.SpecList) {
 	var wg sync.WaitGroup
 	wg.Add(len(targetGroup))
 
-	for _, server := range targetGroup {
+	var jobs []*RemoteJob
 
-		sshConf := &ssh.ClientConfig{
-			User:            server.Username,
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	for i, server := range targetGroup {
+
+		sshConf, err := clientConfig(server, keys, hostKeys)
+		if err != nil {
+			printErr(err.Error())
+			wg.Done()
+			continue
 		}
 
-		if server.PassAuth {
-			sshConf.Auth = []ssh.AuthMethod{ssh.Password(server.Password)}
-		} else {
-			terminal.Information("SSH Key Auth is not yet implemented")
+		var jumpHosts []jumpHost
+		for _, hop := range jumps[i] {
+			var hopConf *ssh.ClientConfig
+			hopConf, err = clientConfig(hop, keys, hostKeys)
+			if err != nil {
+				break
+			}
+			jumpHosts = append(jumpHosts, jumpHost{Server: hop, SSHConf: hopConf})
+		}
+		if err != nil {
+			printErr(err.Error())
 			wg.Done()
 			continue
 		}
 
 		timeout := time.Second * 7
-		job := RemoteJob{
-			Server:    server,
-			Responses: responses,
-			Errors:    errors,
-			Timeout:   timeout,
-			SSHConf:   sshConf,
-			WaitGroup: &wg,
-			SpecList:  specList,
-			SpecName:  server.Spec}
+		job := &RemoteJob{
+			PlanOnly:    opts.Plan,
+			ConfirmEach: opts.ConfirmEach,
+			Flat:        opts.Flat,
+			Offline:     opts.Offline,
+			Vars:        opts.Vars,
+			Jumps:       jumpHosts,
+			Server:      server,
+			Responses:   responses,
+			Errors:      errors,
+			Timeout:     timeout,
+			SSHConf:     sshConf,
+			WaitGroup:   &wg,
+			SpecList:    specList,
+			SpecName:    server.Spec}
 
 		// Launch it!
+		jobs = append(jobs, job)
 		go job.Run()
 
 	}
@@ -168,6 +254,41 @@ func (s Servers) RemoteConfigure(search string, specList *parser.SpecList) {
 	wg.Wait()
 
 	time.Sleep(time.Second)
+
+	if opts.Plan {
+		for _, job := range jobs {
+			if job.Plan != nil {
+				job.Plan.Print()
+			}
+		}
+		return
+	}
+
+	printSummary(jobs)
+}
+
+// Prints the changed/ok/ran/skipped/failed counts of every job in a table
+func printSummary(jobs []*RemoteJob) {
+
+	collumns := []string{"Name", "Host", "Changed", "OK", "Ran", "Skipped", "Failed", "Services", "Status"}
+	var rows [][]string
+
+	for _, job := range jobs {
+		rows = append(rows, []string{
+			job.Server.Name,
+			job.Server.displayHost(),
+			fmt.Sprint(job.Summary.Changed),
+			fmt.Sprint(job.Summary.OK),
+			fmt.Sprint(job.Summary.Ran),
+			fmt.Sprint(job.Summary.Skipped),
+			fmt.Sprint(job.Summary.Failed),
+			job.Summary.ServiceList(),
+			job.Status,
+		})
+	}
+
+	terminal.Information("Configuration summary:")
+	printTable(collumns, rows)
 }
 
 func printResp(msg string) {
@@ -189,191 +310,110 @@ func (job *RemoteJob) Run() {
 
 	defer job.WaitGroup.Done()
 
-	// Open a tcp connection with a timeout
-	job.Responses <- fmt.Sprintf(line, "*", "Opening a new TCP connection...")
-	conn, err := net.DialTimeout("tcp", job.Server.Host+":22", job.Timeout)
+	// Anything that returns before the spec has run couldn't reach the server
+	job.Status = "unreachable"
 
-	if err != nil {
-		job.Errors <- fmt.Errorf(line, "X", "Unable to open TCP connection! Aborting futher tasks for this server..")
-		return
+	// Hop through each of the jump hosts, every hop tunnels through the one before it
+	var bastion *ssh.Client
+	for _, hop := range job.Jumps {
+		job.Responses <- fmt.Sprintf(line, "*", "Connecting to jump host "+hop.Server.address()+"...")
+		client, err := job.dial(bastion, hop.Server.address(), hop.SSHConf)
+		if err != nil {
+			job.Errors <- fmt.Errorf(line, "X", "Unable to connect to jump host "+hop.Server.address()+"! Aborting futher tasks for this server..")
+			job.Errors <- fmt.Errorf("Error: %s", err)
+			return
+		}
+		defer client.Close()
+		bastion = client
+		job.Responses <- fmt.Sprintf(line, "✓", "Connected to jump host "+hop.Server.address()+"!")
 	}
-	job.Conn = conn // so that it gets wrapped with our timeout funcs
-	job.Responses <- fmt.Sprintf(line, "✓", "TCP connection Opened!")
 
 	// Get an ssh client
 	job.Responses <- fmt.Sprintf(line, "*", "Creating new ssh client...")
-	c, chans, reqs, err := ssh.NewClientConn(job.Conn, job.Server.Host, job.SSHConf)
+	client, err := job.dial(bastion, job.Server.address(), job.SSHConf)
 	if err != nil {
-		fmt.Println(err.Error())
 		job.Errors <- fmt.Errorf(line, "X", "Unable to create SSH client! Aborting futher tasks for this server..")
+		job.Errors <- fmt.Errorf("Error: %s", err)
 		return
 	}
-	job.Client = ssh.NewClient(c, chans, reqs)
-	defer job.Client.Close()
+	job.Client = client
 	job.Responses <- fmt.Sprintf(line, "✓", "SSH client creation Succeeded!")
 
-	// Elevate permissions
-	job.Responses <- fmt.Sprintf(line, "*", "Attempting to elevate permissions...")
-	err = job.runCommand("sudo uname", "sudo uname")
-	if err != nil {
-		job.Errors <- fmt.Errorf(line, "X", "Permission Elevation Failed! Aborting futher tasks for this server..")
-		return
-	}
-	job.Responses <- fmt.Sprintf(line, "✓", "Permission Elevation Succeeded!")
-
-	// Run pre configure commands
-	preCmds := job.SpecList.PreCmds(job.SpecName)
-	for _, preCmd := range preCmds {
-		job.Responses <- fmt.Sprintf(line, "*", "Running Pre-Configuration Command...")
-		err = job.runCommand(preCmd, "Pre-Configuration")
-		if err != nil {
-			job.Errors <- fmt.Errorf(line, "X", "Pre-Configuration Command Failed! Aborting futher tasks for this server..")
-			job.Errors <- fmt.Errorf("Error: %s", err)
-			return
-		}
-		job.Responses <- fmt.Sprintf(line, "✓", "Pre-Configuration Command Succeeded!")
+	// Run the spec over the connection
+	remote := transport.NewSSH(client)
+	defer remote.Close()
+
+	specJob := engine.Job{
+		Name:      job.Server.Name + " - " + job.Server.Host,
+		Transport: remote,
+		SpecList:  job.SpecList,
+		SpecName:  job.SpecName,
+		Vars:      engine.NewVars(job.SpecName, job.Server.Class, job.Server.Sequence, job.Server.Locale),
+		VarLayers: append(job.Server.VarLayers(), job.Vars...),
+		Responses: job.Responses,
+		Errors:    job.Errors,
+
+		ConfirmEach: job.ConfirmEach,
+		Flat:        job.Flat,
+		Offline:     job.Offline,
 	}
 
-	// Run Apt-Get Commands
-	aptCmds := job.SpecList.AptGetCmds(job.SpecName)
-	job.Responses <- fmt.Sprintf(line, "*", "Running apt-get Command...")
-	for _, aptCmd := range aptCmds {
-		err = job.runCommand(aptCmd, "apt-get")
-		if err != nil {
-			job.Errors <- fmt.Errorf(line, "X", "Command apt-get Failed! Aborting futher tasks for this server..")
-			return
+	if job.PlanOnly {
+		job.Responses <- fmt.Sprintf(line, "*", "Planning changes...")
+		plan, err := specJob.Plan()
+		if err == nil {
+			job.Plan = plan
+			job.Responses <- fmt.Sprintf(line, "✓", "Planning Succeeded!")
 		}
-		job.Responses <- fmt.Sprintf(line, "✓", "Command apt-get Succeeded!")
-	}
-
-	// Transfer any files we need to transfer
-	fileList := job.SpecList.DebianFileTransferList(job.SpecName)
-	job.Responses <- fmt.Sprintf(line, "*", "Starting remote file transfer...")
-	err = job.transferFiles(fileList, "Configuration and Content Files")
-	if err != nil {
-		job.Errors <- fmt.Errorf(line, "X", "File Transfer Failed! Aborting futher tasks for this server..")
 		return
 	}
-	job.Responses <- fmt.Sprintf(line, "✓", "File Transfer Succeeded!")
-
-	// Run post configure commands
-	postCmds := job.SpecList.PostCmds(job.SpecName)
-	for _, postCmd := range postCmds {
-		job.Responses <- fmt.Sprintf(line, "*", "Running Post-Configuration Command...")
-		err = job.runCommand(postCmd, "Post-Configuration")
-		if err != nil {
-			job.Errors <- fmt.Errorf(line, "X", "Post-Configuration Command Failed! Aborting futher tasks for this server..")
-			job.Errors <- fmt.Errorf("Error: %s", err)
-			return
-		}
-		job.Responses <- fmt.Sprintf(line, "✓", "Post-Configuration Command Succeeded!")
-	}
-}
 
-func (j *RemoteJob) runCommand(cmd string, name string) error {
-
-	// Open an ssh session
-	session, err := j.Client.NewSession()
+	err = specJob.Run()
+	job.Summary = specJob.Summary
 	if err != nil {
-		return err
+		job.Status = "failed"
+	} else {
+		job.Status = "ok"
 	}
-	defer session.Close()
+}
 
-	var stdoutBuf, stderrBuf bytes.Buffer
-	session.Stdout = &stdoutBuf
-	session.Stderr = &stderrBuf
+// Opens an ssh connection to an address, either directly or tunneled through a bastion
+func (j *RemoteJob) dial(bastion *ssh.Client, address string, sshConf *ssh.ClientConfig) (*ssh.Client, error) {
 
-	err = session.Run(cmd)
+	var conn net.Conn
+	var err error
 
-	// TODO handle more verbose output, maybe from a verbose cli flag
+	if bastion == nil {
+		conn, err = net.DialTimeout("tcp", address, j.Timeout)
+	} else {
+		conn, err = bastion.Dial("tcp", address)
+	}
 	if err != nil {
-		j.Responses <- stdoutBuf.String()
-		j.Responses <- stderrBuf.String()
+		return nil, err
 	}
 
-	return err
-
-}
-
-func (j *RemoteJob) transferFiles(fileList *parser.FileTransfers, name string) error {
-
-	line := addSpaces("[%s] ["+j.Server.Name+" - "+j.Server.Host+"]", 45) + " >> %s " // status, name, host, message
-
-	// open an sftp session.
-	sftpClient, err := sftp.NewClient(j.Client)
+	c, chans, reqs, err := ssh.NewClientConn(conn, address, sshConf)
 	if err != nil {
-		return err
+		conn.Close()
+		return nil, err
 	}
-	defer sftpClient.Close()
-
-	// Defer cleanup
-	defer j.runCommand("sudo rm -rf /tmp/cm/*", "")
-
-	for _, file := range *fileList {
-
-		// Make our temp folder
-		j.runCommand("mkdir -p /tmp/cm/"+file.Folder, "")
-		err = j.runCommand("sudo mkdir -p "+file.Folder, "") // should prob add chown and chmod to the config structs to set it afterwards
-		if err != nil {
-			j.Errors <- fmt.Errorf(line, "X", "Unable to make directory: "+file.Folder)
-			return err
-		}
-
-		j.Responses <- fmt.Sprintf(line, "*", "Uploading file: "+file.Destination)
-
-		// Read the local file
-		////////////////..........
-		lf, err := os.Open(file.Source)
-		defer lf.Close()
-		if err != nil {
-			j.Errors <- fmt.Errorf(line, "X", "Unable to open local file: "+file.Source)
-			return err
-		}
-
-		lfi, err := lf.Stat()
-		if err != nil {
-			j.Errors <- fmt.Errorf(line, "X", "Unable to inspect local file: "+file.Source)
-			return err
-		}
-
-		fileSize := lfi.Size()
-		fileBytes := make([]byte, fileSize)
-
-		_, err = lf.Read(fileBytes)
-		if err != nil {
-			j.Errors <- fmt.Errorf(line, "X", "Unable to read local file: "+file.Source)
-			return err
-		}
-
-		// Write the remote file
-		////////////////..........
-		rf, err := sftpClient.Create("/tmp/cm" + file.Destination)
-		defer rf.Close()
-
-		if err != nil {
-			j.Errors <- fmt.Errorf(line, "X", "Unable to create file: "+file.Destination)
-			return err
-		}
-		if _, err := rf.Write(fileBytes); err != nil {
-			j.Errors <- fmt.Errorf(line, "X", "Unable to write file: "+file.Destination)
-			return err
-		}
 
-		// mv
-		j.runCommand("sudo mv /tmp/cm"+file.Destination+" "+file.Destination, "")
+	return ssh.NewClient(c, chans, reqs), nil
+}
 
-		j.Responses <- fmt.Sprintf(line, "✓", "Completed upload of file: "+file.Destination)
+// Shows the spec of the server, and the group it comes from when the server doesn't set one
+func (s *Server) displaySpec() string {
+	if s.SpecFrom != "" {
+		return s.Spec + " (" + s.SpecFrom + ")"
 	}
-
-	return nil
-
+	return s.Spec
 }
 
 // Prints all server config data in a table
 func (servers Servers) PrintAllServerInfo() {
 
 	// Build the table elements
-	collumns := []string{"#", "Name", "Host", "Username", "Spec", "Password Auth?"}
+	collumns := []string{"#", "Name", "Host", "Username", "Spec", "Groups", "Password Auth?", "Identity File", "Jump Host"}
 
 	var rows [][]string
 
@@ -381,10 +421,13 @@ func (servers Servers) PrintAllServerInfo() {
 		rows = append(rows, []string{
 			fmt.Sprint(i + 1),
 			s.Name,
-			s.Host,
+			s.displayHost(),
 			s.Username,
-			s.Spec,
+			s.displaySpec(),
+			strings.Join(s.Groups, ", "),
 			fmt.Sprintf("%t", s.PassAuth),
+			strings.Join(s.IdentityFile, ", "),
+			strings.Join(s.JumpHost, " -> "),
 		})
 	}
 
@@ -394,27 +437,27 @@ func (servers Servers) PrintAllServerInfo() {
 // Gets the target group of servers for a specified spec
 func (servers Servers) getTargetGroup(search string) Servers {
 
-	collumns := []string{"Name", "Host", "Username", "Spec", "Password Auth?"}
+	collumns := []string{"Name", "Host", "Username", "Spec", "Password Auth?", "Identity File", "Jump Host"}
 	var rows [][]string
 	var targetGroup Servers
 
-	for _, s := range servers {
-		if s.Spec == search || s.Name == search {
+	for _, s := range servers.Matching(search) {
 
-			rows = append(rows, []string{
-				s.Name,
-				s.Host,
-				s.Username,
-				s.Spec,
-				fmt.Sprintf("%t", s.PassAuth),
-			})
+		rows = append(rows, []string{
+			s.Name,
+			s.displayHost(),
+			s.Username,
+			s.Spec,
+			fmt.Sprintf("%t", s.PassAuth),
+			strings.Join(s.IdentityFile, ", "),
+			strings.Join(s.JumpHost, " -> "),
+		})
 
-			targetGroup = append(targetGroup, s)
-		}
+		targetGroup = append(targetGroup, s)
 	}
 
 	if len(rows) == 0 {
-		terminal.Information(fmt.Sprintf("I couldn't find any servers with the name or spec of: [%s], here is what I do have: ", search))
+		terminal.Information(fmt.Sprintf("I couldn't find any servers with the name, spec or group of: [%s], here is what I do have: ", search))
 		servers.PrintAllServerInfo()
 		os.Exit(0)
 	}
@@ -426,6 +469,60 @@ func (servers Servers) getTargetGroup(search string) Servers {
 
 }
 
+// Returns the servers with the given name or spec, or in the given group
+func (servers Servers) Matching(search string) Servers {
+	var matching Servers
+	for _, s := range servers {
+		if s.Spec == search || s.Name == search || s.InGroup(search) {
+			matching = append(matching, s)
+		}
+	}
+	return matching
+}
+
+// Compares the specs of each server with the lock, and returns whether to go ahead
+func checkLock(targetGroup Servers, specList *parser.SpecList, opts ConfigureOptions) bool {
+	var mismatches []string
+	checked := make(map[string]bool)
+	for _, server := range targetGroup {
+		// Servers without a spec fail on their own once they are configured
+		if server.Spec == "" || checked[server.Spec] {
+			continue
+		}
+		checked[server.Spec] = true
+
+		// Git content is fetched to compare its commit with the lock
+		if err := specList.PrepareContent(server.Spec, content.CacheDir()); err != nil {
+			terminal.ShowErrorMessage("Unable to fetch content!", err.Error())
+			return false
+		}
+
+		found, err := specList.CheckLock(opts.Lock, server.Spec)
+		if err != nil {
+			terminal.ShowErrorMessage("Unable to check the lock!", err.Error())
+			return false
+		}
+		mismatches = append(mismatches, found...)
+	}
+
+	if len(mismatches) == 0 {
+		return true
+	}
+
+	if opts.IgnoreLock {
+		for _, mismatch := range mismatches {
+			terminal.Notice("Ignoring the lock: " + mismatch)
+		}
+		return true
+	}
+
+	for _, mismatch := range mismatches {
+		terminal.ErrorLine(mismatch)
+	}
+	terminal.ShowErrorMessage("Specs don't match cm.lock!", "Run cm lock to update the lock, or configure with --ignore-lock to go ahead anyway.")
+	return false
+}
+
 // Table helper
 func printTable(header []string, rows [][]string) {
 	table := tablewriter.NewWriter(os.Stdout)
@@ -0,0 +1,211 @@
+package servers
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The subset of an OpenSSH client config file (~/.ssh/config) that cm understands
+type sshConfig struct {
+	hosts []sshConfigHost
+}
+
+// A single Host block of an ssh config file
+type sshConfigHost struct {
+	patterns []string
+	options  map[string][]string // keyed by lower case keyword
+}
+
+// Reads the current users ~/.ssh/config, a missing file results in an empty config
+func readUserSSHConfig() (*sshConfig, error) {
+	currentUser, _ := user.Current()
+	return readSSHConfig(currentUser.HomeDir + "/.ssh/config")
+}
+
+// Reads an ssh config file. Only Host blocks are supported, Match blocks and Include are skipped.
+func readSSHConfig(file string) (*sshConfig, error) {
+	config := new(sshConfig)
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	defer f.Close()
+
+	// Options before the first Host block apply to every host
+	current := sshConfigHost{patterns: []string{"*"}, options: make(map[string][]string)}
+	skipping := false
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Keywords and arguments are separated by whitespace and/or a single "="
+		fields := strings.Fields(strings.Replace(line, "=", " ", 1))
+		keyword := strings.ToLower(fields[0])
+		args := fields[1:]
+
+		switch keyword {
+		case "host":
+			config.hosts = append(config.hosts, current)
+			current = sshConfigHost{patterns: args, options: make(map[string][]string)}
+			skipping = false
+		case "match":
+			config.hosts = append(config.hosts, current)
+			current = sshConfigHost{options: make(map[string][]string)}
+			skipping = true
+		default:
+			if !skipping && len(args) > 0 {
+				current.options[keyword] = append(current.options[keyword], strings.Trim(strings.Join(args, " "), "\""))
+			}
+		}
+	}
+	config.hosts = append(config.hosts, current)
+
+	return config, scanner.Err()
+}
+
+// Returns the first value of a keyword for a given host alias, like ssh the first match wins
+func (c *sshConfig) get(alias, keyword string) string {
+	values := c.getAll(alias, keyword)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Returns every value of a keyword for a given host alias, in the order they appear
+func (c *sshConfig) getAll(alias, keyword string) []string {
+	var values []string
+	for _, host := range c.hosts {
+		if host.matches(alias) {
+			values = append(values, host.options[strings.ToLower(keyword)]...)
+		}
+	}
+	return values
+}
+
+// Checks a host alias against the patterns of a Host block, honouring negated patterns
+func (h *sshConfigHost) matches(alias string) bool {
+	matched := false
+	for _, pattern := range h.patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		ok, _ := filepath.Match(strings.TrimPrefix(pattern, "!"), alias)
+		if ok && negated {
+			return false
+		}
+		if ok {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// Fills in anything the inventory leaves unset from the ssh config. Values in the inventory always win.
+func (s *Server) applySSHConfig(config *sshConfig) {
+	alias := s.Host
+
+	if hostName := config.get(alias, "HostName"); hostName != "" {
+		s.Host = strings.Replace(hostName, "%h", alias, -1)
+	}
+	if s.Username == "" {
+		s.Username = config.get(alias, "User")
+	}
+	if s.Port == 0 {
+		s.Port, _ = strconv.Atoi(config.get(alias, "Port"))
+	}
+	if len(s.JumpHost) == 0 {
+		if proxyJump := config.get(alias, "ProxyJump"); proxyJump != "" && proxyJump != "none" {
+			s.JumpHost = strings.Split(proxyJump, ",")
+		}
+	}
+	s.ConfigIdentityFile = append(s.ConfigIdentityFile, config.getAll(alias, "IdentityFile")...)
+}
+
+// Builds the jump hosts of a server in the order they are dialled. Like ssh, the first hop is reached
+// through its own ProxyJump from the ssh config, so chains like bastion -> gateway -> target work;
+// the later hops are reached through the hops before them.
+func jumpServers(target Server, config *sshConfig) ([]Server, error) {
+	return jumpChain(target, config, []string{target.Host})
+}
+
+// Unexported func for jumpServers, path holds the hosts leading to the server, to catch cycles
+func jumpChain(server Server, config *sshConfig, path []string) ([]Server, error) {
+	var hops []Server
+	for i, hop := range server.JumpHost {
+		jump := newJumpServer(hop, server, config)
+		if i == 0 && len(jump.JumpHost) > 0 {
+			for _, host := range path {
+				if host == jump.Name {
+					return nil, fmt.Errorf("jump hosts form a cycle: %s -> %s", strings.Join(path, " -> "), jump.Name)
+				}
+			}
+			before, err := jumpChain(jump, config, append(path, jump.Name))
+			if err != nil {
+				return nil, err
+			}
+			hops = append(hops, before...)
+		}
+		hops = append(hops, jump)
+	}
+	return hops, nil
+}
+
+// Builds the Server for a single jump host hop, written as [user@]host[:port] or an ssh config alias
+func newJumpServer(hop string, target Server, config *sshConfig) Server {
+	hop = strings.TrimSpace(hop)
+	jump := Server{Name: hop}
+
+	if at := strings.LastIndex(hop, "@"); at >= 0 {
+		jump.Username = hop[:at]
+		hop = hop[at+1:]
+	}
+	if colon := strings.LastIndex(hop, ":"); colon >= 0 && !strings.HasSuffix(hop, "]") {
+		jump.Port, _ = strconv.Atoi(hop[colon+1:])
+		hop = hop[:colon]
+	}
+	jump.Host = strings.Trim(hop, "[]")
+
+	jump.applySSHConfig(config)
+
+	// Fall back on the targets login and keys, the usual setup for a bastion
+	if jump.Username == "" {
+		jump.Username = target.Username
+	}
+	if len(jump.IdentityFile) == 0 && len(jump.ConfigIdentityFile) == 0 {
+		jump.IdentityFile = target.IdentityFile
+		jump.ConfigIdentityFile = target.ConfigIdentityFile
+	}
+
+	return jump
+}
+
+// Returns the host:port address of the server
+func (s *Server) address() string {
+	port := s.Port
+	if port == 0 {
+		port = 22
+	}
+
+	return net.JoinHostPort(s.Host, strconv.Itoa(port))
+}
+
+// Returns the host for display, with the port only when it has been set
+func (s *Server) displayHost() string {
+	if s.Port == 0 {
+		return s.Host
+	}
+	return s.address()
+}
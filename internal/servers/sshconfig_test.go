@@ -0,0 +1,80 @@
+package servers_test
+
+import (
+	"testing"
+
+	"github.com/praveensastry/cm/internal/servers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplySSHConfig(t *testing.T) {
+	// Host patterns, Port, User and ProxyJump, with the jump host taken from its own block
+	web, jumps, err := servers.ApplySSHConfig("testdata/ssh_config", servers.Server{Name: "web", Host: "web-01"})
+	assert.NoError(t, err)
+	assert.Equal(t, "deploy", web.Username)
+	assert.Equal(t, "web-01:2222", web.Address())
+	assert.Equal(t, []string{"ops@bastion:2200"}, web.JumpHost)
+	assert.Equal(t, []string{"~/.ssh/cm_test_missing_key"}, web.ConfigIdentityFile)
+	if assert.Len(t, jumps, 1) {
+		assert.Equal(t, "ops", jumps[0].Username)
+		assert.Equal(t, "bastion.example.com:2200", jumps[0].Address())
+		assert.Equal(t, []string{"~/.ssh/bastion_ed25519", "~/.ssh/cm_test_missing_key"}, jumps[0].ConfigIdentityFile)
+	}
+
+	// Negated patterns keep a host out of a block, Match blocks are skipped
+	legacy, jumps, err := servers.ApplySSHConfig("testdata/ssh_config", servers.Server{Name: "legacy", Host: "web-legacy"})
+	assert.NoError(t, err)
+	assert.Equal(t, "fallback", legacy.Username)
+	assert.Equal(t, "web-legacy:22", legacy.Address())
+	assert.Empty(t, jumps)
+
+	// HostName expands %h, ProxyJump none means no jump host, and the inventory always wins
+	db, jumps, err := servers.ApplySSHConfig("testdata/ssh_config", servers.Server{Name: "db", Host: "db", Username: "admin", Port: 2022})
+	assert.NoError(t, err)
+	assert.Equal(t, "admin", db.Username)
+	assert.Equal(t, "db.internal.example.com:2022", db.Address())
+	assert.Empty(t, jumps)
+
+	// A jump host written as user@[host]:port without a config block falls back on the target
+	_, jumps, err = servers.ApplySSHConfig("testdata/missing_config", servers.Server{Name: "app", Host: "app", Username: "deploy", IdentityFile: []string{"~/.ssh/app"}, JumpHost: []string{"[10.0.0.5]:2200"}})
+	assert.NoError(t, err)
+	if assert.Len(t, jumps, 1) {
+		assert.Equal(t, "deploy", jumps[0].Username)
+		assert.Equal(t, "10.0.0.5:2200", jumps[0].Address())
+		assert.Equal(t, []string{"~/.ssh/app"}, jumps[0].IdentityFile)
+	}
+}
+
+func TestApplySSHConfigJumpChains(t *testing.T) {
+	address := func(jumps []servers.Server) []string {
+		var addresses []string
+		for _, jump := range jumps {
+			addresses = append(addresses, jump.Address())
+		}
+		return addresses
+	}
+
+	// A jump host with a ProxyJump of its own is reached through it first
+	_, jumps, err := servers.ApplySSHConfig("testdata/ssh_config", servers.Server{Name: "app", Host: "app"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bastion.example.com:22", "gw.example.com:22"}, address(jumps))
+
+	// Only the first hop of a list, the later ones are reached through the hops before them
+	_, jumps, err = servers.ApplySSHConfig("testdata/ssh_config", servers.Server{Name: "multi", Host: "multi"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"bastion.example.com:22", "gw.example.com:22", "db.internal.example.com:22"}, address(jumps))
+
+	_, _, err = servers.ApplySSHConfig("testdata/ssh_config", servers.Server{Name: "loop", Host: "loop-a"})
+	assert.EqualError(t, err, "jump hosts form a cycle: loop-a -> loop-b -> loop-a")
+}
+
+func TestLoadIdentityFiles(t *testing.T) {
+	// Missing identity files from ~/.ssh/config are skipped, like ssh does
+	web, _, err := servers.ApplySSHConfig("testdata/ssh_config", servers.Server{Name: "web", Host: "web-01"})
+	assert.NoError(t, err)
+	assert.NoError(t, servers.LoadIdentityFiles(web))
+
+	// But those set in the inventory have to be there
+	web.IdentityFile = []string{"testdata/missing_key"}
+	assert.Error(t, servers.LoadIdentityFiles(web))
+}
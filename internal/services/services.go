@@ -0,0 +1,132 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/praveensastry/cm/internal/transport"
+)
+
+// The init system of a target, and the commands it takes to inspect and change services
+type InitSystem struct {
+	name    string
+	active  string // prints active when the service is running
+	enabled string // prints enabled when the service starts at boot
+	action  func(action, service string) string
+}
+
+var (
+	Systemd = &InitSystem{
+		name:    "systemd",
+		active:  "systemctl is-active %s 2>/dev/null || true",
+		enabled: "systemctl is-enabled %s 2>/dev/null || true",
+		action: func(action, service string) string {
+			return "sudo systemctl " + action + " " + service
+		},
+	}
+	SysVInit = &InitSystem{
+		name:    "sysvinit",
+		active:  "sudo service %s status >/dev/null 2>&1 && echo active || echo inactive",
+		enabled: "ls /etc/rc[2345].d/S[0-9][0-9]%s >/dev/null 2>&1 && echo enabled || echo disabled",
+		action: func(action, service string) string {
+			switch action {
+			case "enable":
+				return "if command -v update-rc.d >/dev/null 2>&1; then sudo update-rc.d " + service + " enable; else sudo chkconfig " + service + " on; fi"
+			case "disable":
+				return "if command -v update-rc.d >/dev/null 2>&1; then sudo update-rc.d " + service + " disable; else sudo chkconfig " + service + " off; fi"
+			}
+			return "sudo service " + service + " " + action
+		},
+	}
+)
+
+// What a service is doing on the target
+type Status struct {
+	Running bool
+	Enabled bool
+	Fixed   bool // whether it starts at boot is decided elsewhere, eg: static units, so it can't be enabled or disabled
+}
+
+// What systemctl is-enabled prints for units that start at boot, and for units that can't be
+// enabled or disabled themselves
+var (
+	enabledStates = map[string]bool{"enabled": true, "enabled-runtime": true, "alias": true, "indirect": true}
+	fixedStates   = map[string]bool{"static": true, "generated": true, "transient": true}
+)
+
+// The state a spec wants a service in, empty fields are left alone
+type Want struct {
+	State   string // running or stopped
+	Enabled string // enabled or disabled
+}
+
+// Detects whether the target boots with systemd or sysvinit
+func Detect(t transport.Transport) (*InitSystem, error) {
+	output, err := t.Run("if test -d /run/systemd/system; then echo systemd; else echo sysvinit; fi")
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.TrimSpace(output) {
+	case "systemd":
+		return Systemd, nil
+	case "sysvinit":
+		return SysVInit, nil
+	}
+
+	return nil, fmt.Errorf("unable to detect the init system, got [%s]", strings.TrimSpace(output))
+}
+
+// The name of the init system
+func (i *InitSystem) Name() string {
+	return i.name
+}
+
+// Asks the target whether a service is running and enabled
+func (i *InitSystem) Status(t transport.Transport, service string) (Status, error) {
+	var status Status
+
+	active, err := t.Run(fmt.Sprintf(i.active, transport.Quote(service)))
+	if err != nil {
+		return status, err
+	}
+	status.Running = strings.TrimSpace(active) == "active"
+
+	enabled, err := t.Run(fmt.Sprintf(i.enabled, transport.Quote(service)))
+	if err != nil {
+		return status, err
+	}
+	state := strings.TrimSpace(enabled)
+	status.Enabled = enabledStates[state]
+	status.Fixed = fixedStates[state]
+
+	return status, nil
+}
+
+// Returns the command that runs an action on a service: start, stop, reload, restart, enable or disable
+func (i *InitSystem) Command(action, service string) string {
+	return i.action(action, transport.Quote(service))
+}
+
+// Returns the actions that take a service from its status to the wanted state, in the order to run them.
+// Services whose start at boot is Fixed are never enabled or disabled.
+func Actions(status Status, want Want) []string {
+	var actions []string
+
+	switch {
+	case status.Fixed:
+	case want.Enabled == "enabled" && !status.Enabled:
+		actions = append(actions, "enable")
+	case want.Enabled == "disabled" && status.Enabled:
+		actions = append(actions, "disable")
+	}
+
+	switch {
+	case want.State == "running" && !status.Running:
+		actions = append(actions, "start")
+	case want.State == "stopped" && status.Running:
+		actions = append(actions, "stop")
+	}
+
+	return actions
+}
@@ -0,0 +1,74 @@
+package services_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/praveensastry/cm/internal/services"
+	"github.com/praveensastry/cm/internal/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetect(t *testing.T) {
+	for output, want := range map[string]*services.InitSystem{"systemd\n": services.Systemd, "sysvinit\n": services.SysVInit} {
+		fake := transport.NewFake()
+		fake.Responder = func(cmd string) (string, error) {
+			return output, nil
+		}
+
+		initSystem, err := services.Detect(fake)
+		assert.NoError(t, err)
+		assert.Equal(t, want.Name(), initSystem.Name())
+	}
+}
+
+func TestStatus(t *testing.T) {
+	cases := []struct {
+		active, enabled string
+		want            services.Status
+	}{
+		{"active\n", "enabled\n", services.Status{Running: true, Enabled: true}},
+		{"inactive\n", "disabled\n", services.Status{}},
+		{"failed\n", "enabled-runtime\n", services.Status{Enabled: true}},
+		{"active\n", "alias\n", services.Status{Running: true, Enabled: true}},
+		{"active\n", "indirect\n", services.Status{Running: true, Enabled: true}},
+		{"active\n", "static\n", services.Status{Running: true, Fixed: true}},
+		{"inactive\n", "generated\n", services.Status{Fixed: true}},
+		{"inactive\n", "", services.Status{}},
+	}
+
+	for _, c := range cases {
+		fake := transport.NewFake()
+		fake.Responder = func(cmd string) (string, error) {
+			if strings.HasPrefix(cmd, "systemctl is-active 'nginx'") {
+				return c.active, nil
+			}
+			return c.enabled, nil
+		}
+
+		status, err := services.Systemd.Status(fake, "nginx")
+		assert.NoError(t, err)
+		assert.Equal(t, c.want, status, c.enabled)
+	}
+}
+
+func TestActions(t *testing.T) {
+	running := services.Want{State: "running", Enabled: "enabled"}
+	stopped := services.Want{State: "stopped", Enabled: "disabled"}
+
+	assert.Equal(t, []string{"enable", "start"}, services.Actions(services.Status{}, running))
+	assert.Empty(t, services.Actions(services.Status{Running: true, Enabled: true}, running))
+	assert.Equal(t, []string{"disable", "stop"}, services.Actions(services.Status{Running: true, Enabled: true}, stopped))
+	assert.Empty(t, services.Actions(services.Status{}, stopped))
+	assert.Equal(t, []string{"start"}, services.Actions(services.Status{Enabled: true}, services.Want{State: "running"}), "an empty enabled is left alone")
+
+	// Static units can only be started and stopped
+	assert.Equal(t, []string{"start"}, services.Actions(services.Status{Fixed: true}, running))
+	assert.Equal(t, []string{"stop"}, services.Actions(services.Status{Running: true, Fixed: true}, stopped))
+}
+
+func TestCommand(t *testing.T) {
+	assert.Equal(t, "sudo systemctl restart 'nginx'", services.Systemd.Command("restart", "nginx"))
+	assert.Equal(t, "sudo service 'nginx' reload", services.SysVInit.Command("reload", "nginx"))
+	assert.Contains(t, services.SysVInit.Command("enable", "nginx"), "sudo update-rc.d 'nginx' enable")
+}
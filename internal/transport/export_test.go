@@ -0,0 +1,7 @@
+package transport
+
+// The stat command and its parser, so that they can be tested together against real files
+var (
+	StatCommand = statCommand
+	ParseStat   = parseStat
+)
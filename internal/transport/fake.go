@@ -0,0 +1,89 @@
+package transport
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+// An in-memory target for testing jobs without a server. Files and folders live in maps,
+// and commands are recorded rather than run.
+type Fake struct {
+	Files    map[string][]byte
+	Dirs     map[string]bool
+	Commands []string
+	Closed   bool
+
+	// Optionally the mode and owner:group Stat reports for a file, left empty without them
+	Modes  map[string]string
+	Owners map[string]string
+
+	// Optionally decides the outcome of each command, commands succeed with no output without it
+	Responder func(cmd string) (string, error)
+
+	mutex sync.Mutex
+}
+
+// Assembles a new, empty Fake transport
+func NewFake() *Fake {
+	return &Fake{
+		Files: make(map[string][]byte),
+		Dirs:  make(map[string]bool),
+	}
+}
+
+func (t *Fake) Run(cmd string) (string, error) {
+	t.mutex.Lock()
+	t.Commands = append(t.Commands, cmd)
+	responder := t.Responder
+	t.mutex.Unlock()
+
+	if responder == nil {
+		return "", nil
+	}
+
+	return responder(cmd)
+}
+
+func (t *Fake) Put(file File) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.Files[file.Destination] = file.Contents
+	for dir := path.Dir(file.Destination); dir != "/" && dir != "."; dir = path.Dir(dir) {
+		t.Dirs[dir] = true
+	}
+
+	return nil
+}
+
+func (t *Fake) Stat(p string) (FileInfo, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	info := FileInfo{Path: p}
+	if contents, ok := t.Files[p]; ok {
+		info.Exists = true
+		info.Size = int64(len(contents))
+		info.Sha256 = fmt.Sprintf("%x", sha256.Sum256(contents))
+		info.Mode = t.Modes[p]
+		if owner := strings.SplitN(t.Owners[p], ":", 2); len(owner) == 2 {
+			info.Owner, info.Group = owner[0], owner[1]
+		}
+	} else if t.Dirs[p] {
+		info.Exists = true
+		info.IsDir = true
+	}
+
+	return info, nil
+}
+
+func (t *Fake) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.Closed = true
+	return nil
+}
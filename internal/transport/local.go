@@ -0,0 +1,74 @@
+package transport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+)
+
+// Runs jobs on this machine
+type Local struct {
+	staged bool // whether anything was written to /tmp/cm
+}
+
+// Assembles a new Local transport
+func NewLocal() *Local {
+	return new(Local)
+}
+
+func (t *Local) Run(command string) (string, error) {
+
+	cmd := exec.Command("sh", "-c", command)
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	cmd.Stdout = &stdoutBuf
+	cmd.Stderr = &stderrBuf
+
+	err := cmd.Run()
+	if err != nil {
+		return stdoutBuf.String(), &CommandError{Command: command, Stdout: stdoutBuf.String(), Stderr: stderrBuf.String(), Err: err}
+	}
+
+	return stdoutBuf.String(), nil
+}
+
+// Writes the file to /tmp/cm, then moves it into place with sudo
+func (t *Local) Put(file File) error {
+
+	staged := "/tmp/cm" + file.Destination
+
+	// Make our temp folder
+	if err := os.MkdirAll(path.Dir(staged), 0755); err != nil {
+		return err
+	}
+
+	t.staged = true
+	if err := ioutil.WriteFile(staged, file.Contents, 0644); err != nil {
+		return err
+	}
+
+	// mv
+	_, err := t.Run("sudo mv " + Quote(staged) + " " + Quote(file.Destination))
+	return err
+}
+
+func (t *Local) Stat(path string) (FileInfo, error) {
+	output, err := t.Run(statCommand(path))
+	if err != nil {
+		return FileInfo{Path: path}, err
+	}
+
+	return parseStat(path, output), nil
+}
+
+// Cleans up our temp folder, if anything was written to it
+func (t *Local) Close() error {
+	if !t.staged {
+		return nil
+	}
+
+	_, err := t.Run("sudo rm -rf /tmp/cm")
+	return err
+}
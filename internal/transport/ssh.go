@@ -0,0 +1,94 @@
+package transport
+
+import (
+	"bytes"
+	"path"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Runs jobs on a remote server over an ssh connection
+type SSH struct {
+	Client *ssh.Client
+	sftp   *sftp.Client
+}
+
+// Assembles a new SSH transport, the transport takes ownership of the client
+func NewSSH(client *ssh.Client) *SSH {
+	return &SSH{Client: client}
+}
+
+func (t *SSH) Run(cmd string) (string, error) {
+
+	// Open an ssh session
+	session, err := t.Client.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	session.Stdout = &stdoutBuf
+	session.Stderr = &stderrBuf
+
+	err = session.Run(cmd)
+	if err != nil {
+		return stdoutBuf.String(), &CommandError{Command: cmd, Stdout: stdoutBuf.String(), Stderr: stderrBuf.String(), Err: err}
+	}
+
+	return stdoutBuf.String(), nil
+}
+
+// Uploads the file to /tmp/cm over sftp, then moves it into place with sudo
+func (t *SSH) Put(file File) error {
+
+	// open an sftp session.
+	if t.sftp == nil {
+		sftpClient, err := sftp.NewClient(t.Client)
+		if err != nil {
+			return err
+		}
+		t.sftp = sftpClient
+	}
+
+	staged := "/tmp/cm" + file.Destination
+
+	// Make our temp folder
+	if _, err := t.Run("mkdir -p " + Quote(path.Dir(staged))); err != nil {
+		return err
+	}
+
+	rf, err := t.sftp.Create(staged)
+	if err != nil {
+		return err
+	}
+	defer rf.Close()
+
+	if _, err := rf.Write(file.Contents); err != nil {
+		return err
+	}
+
+	// mv
+	_, err = t.Run("sudo mv " + Quote(staged) + " " + Quote(file.Destination))
+	return err
+}
+
+func (t *SSH) Stat(path string) (FileInfo, error) {
+	output, err := t.Run(statCommand(path))
+	if err != nil {
+		return FileInfo{Path: path}, err
+	}
+
+	return parseStat(path, output), nil
+}
+
+// Cleans up our temp folder, if anything was uploaded, and closes the connection
+func (t *SSH) Close() error {
+	if t.sftp != nil {
+		t.Run("sudo rm -rf /tmp/cm")
+		t.sftp.Close()
+	}
+
+	return t.Client.Close()
+}
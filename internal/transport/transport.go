@@ -0,0 +1,100 @@
+package transport
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Transport is how a job reaches the machine it configures. Everything a job does to
+// its target goes through one of these, so the same job can run over ssh, locally, or in memory.
+type Transport interface {
+	// Runs a shell command on the target and returns its stdout
+	Run(cmd string) (string, error)
+
+	// Writes a file to its destination on the target, the destination folder must already exist
+	Put(file File) error
+
+	// Inspects a path on the target, a missing path is not an error
+	Stat(path string) (FileInfo, error)
+
+	// Releases the connection to the target
+	Close() error
+}
+
+// A file to be written to the target
+type File struct {
+	Destination string
+	Contents    []byte
+}
+
+// What a Transport knows about a path on the target
+type FileInfo struct {
+	Path   string
+	Exists bool
+	IsDir  bool
+	Size   int64
+	Mode   string // octal permissions, eg: 644
+	Owner  string
+	Group  string
+	UID    string
+	GID    string
+	Sha256 string // hex checksum of the contents, only set for regular files
+}
+
+// Returned by Run when a command exits unsuccessfully
+type CommandError struct {
+	Command string
+	Stdout  string
+	Stderr  string
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	output := strings.TrimSpace(e.Stderr)
+	if output == "" {
+		output = strings.TrimSpace(e.Stdout)
+	}
+	if output == "" {
+		return fmt.Sprintf("command [%s] failed: %s", e.Command, e.Err)
+	}
+	return fmt.Sprintf("command [%s] failed: %s: %s", e.Command, e.Err, output)
+}
+
+// Quotes a string so that the shell on the target sees it as a single word
+func Quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// The shell command Stat implementations run on the target, its output is parsed by parseStat
+func statCommand(path string) string {
+	p := Quote(path)
+	return "if sudo test -e " + p + "; then sudo stat -c '%F|%s|%a|%U|%G|%u|%g' " + p + "; if sudo test -f " + p + "; then sudo sha256sum " + p + "; fi; fi"
+}
+
+// Parses the output of statCommand
+func parseStat(path, output string) FileInfo {
+	info := FileInfo{Path: path}
+
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	parts := strings.Split(lines[0], "|")
+	if len(parts) != 7 {
+		return info
+	}
+
+	info.Exists = true
+	info.IsDir = parts[0] == "directory"
+	fmt.Sscan(parts[1], &info.Size)
+	info.Mode = parts[2]
+	info.Owner = parts[3]
+	info.Group = parts[4]
+	info.UID = parts[5]
+	info.GID = parts[6]
+
+	if len(lines) > 1 {
+		if checksum := strings.Fields(lines[1]); len(checksum) > 0 {
+			info.Sha256 = checksum[0]
+		}
+	}
+
+	return info
+}
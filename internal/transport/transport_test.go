@@ -0,0 +1,89 @@
+package transport_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/praveensastry/cm/internal/transport"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuote(t *testing.T) {
+	words := []string{
+		"",
+		"plain",
+		"with space",
+		"it's",
+		"''",
+		`"double" $HOME ${var.x} $(reboot) ; rm -rf / & | > < * ? \ ` + "`id`",
+		"new\nline\ttab",
+	}
+
+	for _, word := range words {
+		// The shell has to hand the quoted word to printf unchanged, as a single argument
+		output, err := exec.Command("sh", "-c", "printf '%s|' "+transport.Quote(word)).Output()
+		assert.NoError(t, err, word)
+		assert.Equal(t, word+"|", string(output))
+	}
+
+	assert.Equal(t, `'it'\''s'`, transport.Quote("it's"))
+}
+
+func TestParseStat(t *testing.T) {
+	cases := []struct {
+		output string
+		want   transport.FileInfo
+	}{
+		{"", transport.FileInfo{Path: "/x"}},
+		{"stat: cannot statx\n", transport.FileInfo{Path: "/x"}},
+		{"directory|4096|755|root|root|0|0\n", transport.FileInfo{Path: "/x", Exists: true, IsDir: true, Size: 4096, Mode: "755", Owner: "root", Group: "root", UID: "0", GID: "0"}},
+		{"regular file|6|640|www-data|adm|33|4\nabc123  /x\n", transport.FileInfo{Path: "/x", Exists: true, Size: 6, Mode: "640", Owner: "www-data", Group: "adm", UID: "33", GID: "4", Sha256: "abc123"}},
+		{"regular empty file|0|2775|UNKNOWN|UNKNOWN|1500|1500\ne3b0  /x\n", transport.FileInfo{Path: "/x", Exists: true, Mode: "2775", Owner: "UNKNOWN", Group: "UNKNOWN", UID: "1500", GID: "1500", Sha256: "e3b0"}},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.want, transport.ParseStat("/x", c.output), c.output)
+	}
+}
+
+func TestStatCommand(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cm-transport")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "it's a file")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("hello\n"), 0640))
+	assert.NoError(t, os.Chmod(file, 0640))
+	current, err := user.Current()
+	assert.NoError(t, err)
+
+	stat := func(path string) transport.FileInfo {
+		// Run it as the current user, the targets run it with sudo
+		cmd := strings.Replace(transport.StatCommand(path), "sudo ", "", -1)
+		output, err := exec.Command("sh", "-c", cmd).Output()
+		assert.NoError(t, err, cmd)
+		return transport.ParseStat(path, string(output))
+	}
+
+	info := stat(file)
+	assert.True(t, info.Exists)
+	assert.False(t, info.IsDir)
+	assert.Equal(t, int64(6), info.Size)
+	assert.Equal(t, "640", info.Mode)
+	assert.Equal(t, current.Uid, info.UID)
+	assert.Equal(t, current.Gid, info.GID)
+	assert.Equal(t, current.Username, info.Owner)
+	assert.Equal(t, "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03", info.Sha256)
+
+	info = stat(dir)
+	assert.True(t, info.Exists)
+	assert.True(t, info.IsDir)
+	assert.Empty(t, info.Sha256)
+
+	assert.Equal(t, transport.FileInfo{Path: filepath.Join(dir, "missing")}, stat(filepath.Join(dir, "missing")))
+}
@@ -14,11 +14,16 @@ REQUIRES = nginx, php
 
 [CONTENT]
 	source = spec
-	# can be extended to implement remote content fetch
+	# content can also be fetched from a git repository
 	# example:
 	# source = git
-	# git_command = git clone ...
+	# repo = https://github.com/example/hello_world.git
+	# ref = main
+	# subdir = public
 	debian_root = "/var/www/html/"
+	owner = www-data
+	group = www-data
+	mode = 0644
 
 [COMMANDS]
 
@@ -9,11 +9,12 @@ REQUIRES =
 [PACKAGES]
 	apt_get = nginx
 
-[CONFIGS]
-	debian_root = "/etc/"
-
 [COMMANDS]
 	pre = "sudo apt-get update"
-	post = "sudo service nginx start, sudo service nginx reload"
+	post = "sudo service nginx start"
+
+[HANDLERS.reload_nginx]
+	command = "sudo service nginx reload"
+	watch = /etc/nginx/**
 
 
@@ -14,5 +14,8 @@ REQUIRES =
 	skip_interpolate = true
 
 [COMMANDS]
-	pre = "sudo apt-get install -y software-properties-common, sudo add-apt-repository -y ppa:ondrej/php, sudo apt-get update"
-	post = "sudo service php5-fpm restart"
+	pre = "sudo apt-get install -y software-properties-common, unless='grep -rqs ondrej/php /etc/apt/sources.list.d' sudo add-apt-repository -y ppa:ondrej/php, sudo apt-get update"
+
+[HANDLERS.restart_php_fpm]
+	command = "sudo service php5-fpm restart"
+	watch = /etc/php/**
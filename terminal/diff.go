@@ -0,0 +1,195 @@
+package terminal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lines of unchanged context shown around each change
+const diffContext = 3
+
+// Beyond this many line comparisons the diff gives up on matching lines, and shows a full replacement
+const maxDiffCells = 4000000
+
+// Follows a line that doesn't end with a newline, the way diff -u marks it
+const noNewline = "\\ No newline at end of file"
+
+// A single line of an edit script
+type diffLine struct {
+	op   byte   // ' ', '-' or '+'
+	text string // along with its newline, when it has one
+}
+
+// Returns a unified diff between two texts, or an empty string when they are the same
+func UnifiedDiff(fromName, toName, from, to string) string {
+	if from == to {
+		return ""
+	}
+
+	edits := diffLines(splitKeepingNewlines(from), splitKeepingNewlines(to))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	// Group the edits into hunks, joining changes that are close enough to share context
+	for start := 0; start < len(edits); {
+		if edits[start].op == ' ' {
+			start++
+			continue
+		}
+
+		first := start - diffContext
+		if first < 0 {
+			first = 0
+		}
+
+		last := start
+		for i := start; i < len(edits); i++ {
+			if edits[i].op != ' ' {
+				last = i
+			} else if i-last > 2*diffContext {
+				break
+			}
+		}
+		end := last + diffContext + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		// Work out where the hunk starts in each file
+		fromLine, toLine := 1, 1
+		for _, edit := range edits[:first] {
+			if edit.op != '+' {
+				fromLine++
+			}
+			if edit.op != '-' {
+				toLine++
+			}
+		}
+		fromCount, toCount := 0, 0
+		for _, edit := range edits[first:end] {
+			if edit.op != '+' {
+				fromCount++
+			}
+			if edit.op != '-' {
+				toCount++
+			}
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", fromLine, fromCount, toLine, toCount)
+		for _, edit := range edits[first:end] {
+			if strings.HasSuffix(edit.text, "\n") {
+				fmt.Fprintf(&out, "%c%s", edit.op, edit.text)
+			} else {
+				fmt.Fprintf(&out, "%c%s\n%s\n", edit.op, edit.text, noNewline)
+			}
+		}
+
+		start = end
+	}
+
+	return out.String()
+}
+
+// Prints a unified diff, with removals in red and additions in green
+func PrintDiff(diff string) {
+	fmt.Print(ColorDiff(diff))
+}
+
+// Colors a unified diff, with removals in red and additions in green. Lines are colored by where
+// they are, so a removed line that reads "-- foo" isn't mistaken for the header of a file.
+func ColorDiff(diff string) string {
+	var colored strings.Builder
+	for i, line := range splitLines(diff) {
+		switch {
+		case i < 2 && (strings.HasPrefix(line, "--- ") || strings.HasPrefix(line, "+++ ")):
+			colored.WriteString(AnsiCode("bright") + line + AnsiCode("reset"))
+		case strings.HasPrefix(line, "@@"):
+			colored.WriteString(AnsiCode("fgcyan") + line + AnsiCode("reset"))
+		case strings.HasPrefix(line, "+"):
+			colored.WriteString(AnsiCode("fggreen") + line + AnsiCode("reset"))
+		case strings.HasPrefix(line, "-"):
+			colored.WriteString(AnsiCode("fgred") + line + AnsiCode("reset"))
+		default:
+			colored.WriteString(line)
+		}
+		colored.WriteString("\n")
+	}
+
+	return colored.String()
+}
+
+// Builds the shortest edit script between two sets of lines from their longest common subsequence
+func diffLines(a, b []string) []diffLine {
+	var edits []diffLine
+
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			edits = append(edits, diffLine{'-', line})
+		}
+		for _, line := range b {
+			edits = append(edits, diffLine{'+', line})
+		}
+		return edits
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			edits = append(edits, diffLine{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, diffLine{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		edits = append(edits, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		edits = append(edits, diffLine{'+', b[j]})
+	}
+
+	return edits
+}
+
+// Splits a text into lines that keep their newlines, so that a missing newline at the end counts as a change
+func splitKeepingNewlines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
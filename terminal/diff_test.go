@@ -0,0 +1,69 @@
+package terminal_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/praveensastry/cm/terminal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	assert.Empty(t, terminal.UnifiedDiff("a", "b", "same\n", "same\n"))
+
+	from := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+	to := "one\n2\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven\n"
+	assert.Equal(t, `--- old
++++ new
+@@ -1,5 +1,5 @@
+ one
+-two
++2
+ three
+ four
+ five
+@@ -8,3 +8,4 @@
+ eight
+ nine
+ ten
++eleven
+`, terminal.UnifiedDiff("old", "new", from, to))
+
+	// Changes close together share a hunk
+	assert.Equal(t, 1, strings.Count(terminal.UnifiedDiff("old", "new", "a\nb\nc\nd\n", "A\nb\nc\nD\n"), "@@ -"))
+
+	// Creating a file from nothing
+	assert.Equal(t, "--- old\n+++ new\n@@ -1,0 +1,1 @@\n+new\n", terminal.UnifiedDiff("old", "new", "", "new\n"))
+}
+
+func TestUnifiedDiffNewlineAtEnd(t *testing.T) {
+	assert.Equal(t, `--- old
++++ new
+@@ -1,2 +1,2 @@
+ first
+-last
++last
+\ No newline at end of file
+`, terminal.UnifiedDiff("old", "new", "first\nlast\n", "first\nlast"))
+
+	assert.Equal(t, `--- old
++++ new
+@@ -1,1 +1,1 @@
+-last
+\ No newline at end of file
++last
+`, terminal.UnifiedDiff("old", "new", "last", "last\n"))
+}
+
+func TestColorDiff(t *testing.T) {
+	bright, red, green, cyan, reset := terminal.AnsiCode("bright"), terminal.AnsiCode("fgred"), terminal.AnsiCode("fggreen"), terminal.AnsiCode("fgcyan"), terminal.AnsiCode("reset")
+
+	// Removed and added lines that look like file headers are still removals and additions
+	diff := terminal.UnifiedDiff("old", "new", "--- foo\nkept\n", "+++ bar\nkept\n")
+	assert.Equal(t, bright+"--- old"+reset+"\n"+
+		bright+"+++ new"+reset+"\n"+
+		cyan+"@@ -1,2 +1,2 @@"+reset+"\n"+
+		red+"---- foo"+reset+"\n"+
+		green+"++++ bar"+reset+"\n"+
+		" kept\n", terminal.ColorDiff(diff))
+}
@@ -2,4 +2,7 @@ module github.com/praveensastry/cm/terminal
 
 go 1.15
 
-require golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a
+require (
+	github.com/stretchr/testify v1.6.1
+	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a
+)